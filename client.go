@@ -3,10 +3,19 @@ package datadog
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -16,6 +25,51 @@ const (
 type Client struct {
 	Host   string
 	ApiKey string
+
+	// UseHeaderAuth sends the API key in the DD-API-KEY header instead of
+	// the URL query string, keeping it out of proxy and access logs
+	UseHeaderAuth bool
+
+	// AppKey is the application key, required by APIs which read or
+	// manage data, such as queries and monitors
+	AppKey string
+
+	// Endpoint is the API base URL, defaults to ENDPOINT
+	Endpoint string
+
+	// LogsEndpoint is the logs intake base URL, derived from Endpoint
+	// by default, see `LogsUrl`
+	LogsEndpoint string
+
+	// HTTPClient is used to perform requests, defaults to http.DefaultClient
+	HTTPClient *http.Client
+
+	// Retries is the number of times a request is retried on network errors,
+	// 429 and 5xx responses. RetryBackoff is the initial delay between
+	// attempts, doubled on each retry. Delays, including those requested
+	// by Retry-After headers, are kept between 100ms and 30s.
+	Retries      int
+	RetryBackoff time.Duration
+
+	// Compress enables gzip compression of request bodies
+	Compress bool
+
+	// UseSeriesV2 submits series via the v2 intake, see `PostSeriesV2`
+	UseSeriesV2 bool
+
+	// EventConcurrency is the maximum number of requests in flight while
+	// `PostEvents` submits events, one per request, defaults to 4
+	EventConcurrency int
+
+	rateLimit     *RateLimit
+	rateLimitLock sync.Mutex
+
+	metrics atomic.Value // *clientMetrics
+
+	closeLock sync.Mutex
+	closed    bool
+	inflight  int
+	idle      chan struct{}
 }
 
 type Event struct {
@@ -33,43 +87,318 @@ type Event struct {
 	Key string `json:"aggregation_key,omitempty"`
 	// The type of event being posted. Options: nagios, hudson, jenkins, user, my apps, feed, chef, puppet, git, bitbucket, fabric, capistrano
 	Source string `json:"source_type_name,omitempty"`
+	// The device name the event relates to
+	DeviceName string `json:"device_name,omitempty"`
+	// The ID of a parent event
+	RelatedEventID int64 `json:"related_event_id,omitempty"`
+}
+
+// PostedEvent identifies an event created by Datadog
+type PostedEvent struct {
+	ID  int64  `json:"id"`
+	URL string `json:"url"`
 }
 
 // New creates a new Datadog client. In EC2, datadog expects the hostname to be the
 // instance ID rather than `gethostname(2)`. However, that value can be obtained
-// with `os.Hostname()`.
-func New(host, apiKey string) *Client {
-	return &Client{
+// with `os.Hostname()`. Additional options may be passed to customise the client.
+func New(host, apiKey string, opts ...Option) *Client {
+	c := &Client{
 		Host:   host,
 		ApiKey: apiKey,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // SeriesUrl gets an authenticated URL to POST series data to. In Datadog's examples, this
 // value is 'https://app.datadoghq.com/api/v1/series?api_key=9775a026f1ca7d1...'
+// With `UseHeaderAuth`, the api_key parameter is omitted.
 func (c *Client) SeriesUrl() string {
-	return ENDPOINT + "/series?api_key=" + c.ApiKey
+	return c.apiUrl("/series", nil)
 }
 
 // EventsUrl gets an authenticated URL to POST series data to. In Datadog's examples, this
 // value is 'https://app.datadoghq.com/api/v1/events?api_key=9775a026f1ca7d1...'
+// With `UseHeaderAuth`, the api_key parameter is omitted.
 func (c *Client) EventsUrl() string {
-	return ENDPOINT + "/events?api_key=" + c.ApiKey
+	return c.apiUrl("/events", nil)
+}
+
+// ValidateUrl gets an authenticated URL to validate the API key against.
+func (c *Client) ValidateUrl() string {
+	return c.apiUrl("/validate", nil)
+}
+
+// Validate checks the API key with Datadog. It returns false without an error
+// if the key was rejected. Useful to fail fast on startup.
+func (c *Client) Validate() (bool, error) {
+	req, err := c.newRequest("GET", c.ValidateUrl(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 403 {
+		return false, nil
+	} else if resp.StatusCode != 200 {
+		return false, fmt.Errorf("Bad Datadog response: '%s'", resp.Status)
+	}
+
+	var res struct {
+		Valid bool `json:"valid"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return false, err
+	}
+	return res.Valid, nil
 }
 
 // PostSeries posts an array of series data to the Datadog API. The API expects an object,
 // not an array, so it will be wrapped in a `seriesMessage` with a single
 // `series` field.
 func (c *Client) PostSeries(series []*Series) error {
-	return c.post(c.SeriesUrl(), &seriesMessage{series})
+	return c.PostSeriesContext(context.Background(), series)
+}
+
+// PostSeriesContext is like PostSeries, but aborts the request when ctx
+// is cancelled.
+func (c *Client) PostSeriesContext(ctx context.Context, series []*Series) error {
+	series, dists := splitDistributions(series)
+	if len(dists) == 0 {
+		return c.postSeries(ctx, series)
+	}
+
+	var errs []error
+	if len(series) != 0 {
+		if err := c.postSeries(ctx, series); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := c.PostDistributionsContext(ctx, dists); err != nil {
+		errs = append(errs, err)
+	}
+	return newMultiError(errs)
+}
+
+func (c *Client) postSeries(ctx context.Context, series []*Series) error {
+	if c.UseSeriesV2 {
+		return c.PostSeriesV2Context(ctx, series)
+	}
+	return c.post(ctx, c.SeriesUrl(), &seriesMessage{series}, nil)
+}
+
+// DistributionUrl gets an authenticated URL to POST distribution points to.
+func (c *Client) DistributionUrl() string {
+	return c.apiUrl("/distribution_points", nil)
+}
+
+// PostDistributionsContext posts MT_DISTRIBUTION series to the Datadog API.
+// PostSeries routes distributions automatically, so this is rarely needed.
+func (c *Client) PostDistributionsContext(ctx context.Context, series []*Series) error {
+	return c.post(ctx, c.DistributionUrl(), &seriesMessage{series}, nil)
+}
+
+// SeriesV2Url gets the URL of the v2 series intake. The v2 API only supports
+// header authentication, so the URL never contains the API key.
+func (c *Client) SeriesV2Url() string {
+	return strings.TrimSuffix(c.endpoint(), "/v1") + "/v2/series"
+}
+
+// PostSeriesV2 posts an array of series data to the v2 intake of the Datadog
+// API, converting each series to the v2 schema.
+func (c *Client) PostSeriesV2(series []*Series) error {
+	return c.PostSeriesV2Context(context.Background(), series)
+}
+
+// PostSeriesV2Context is like PostSeriesV2, but aborts the request when ctx
+// is cancelled.
+func (c *Client) PostSeriesV2Context(ctx context.Context, series []*Series) error {
+	msg := &seriesV2Message{Series: make([]*SeriesV2, 0, len(series))}
+	for _, s := range series {
+		msg.Series = append(msg.Series, s.V2())
+	}
+
+	body, err := c.marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest("POST", c.SeriesV2Url(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("DD-API-KEY", c.ApiKey)
+	return c.exchange(req.WithContext(ctx), nil)
 }
 
 // PostEvent post a single event to the Datadog API.
 func (c *Client) PostEvent(event *Event) (err error) {
+	return c.PostEventContext(context.Background(), event)
+}
+
+// PostEventContext is like PostEvent, but aborts the request when ctx
+// is cancelled.
+func (c *Client) PostEventContext(ctx context.Context, event *Event) error {
 	if event.Host == "" {
 		event.Host = c.Host
 	}
-	return c.post(c.EventsUrl(), event)
+	return c.post(ctx, c.EventsUrl(), event, nil)
+}
+
+// PostEvents posts multiple events to the Datadog API. As the API accepts a
+// single event per request, up to `EventConcurrency` events are posted
+// concurrently, sharing the client's compression and retry settings.
+// Errors are aggregated in a `MultiError` if more than one event fails.
+func (c *Client) PostEvents(events []*Event) error {
+	return c.PostEventsContext(context.Background(), events)
+}
+
+// PostEventsContext is like PostEvents, but aborts the requests when ctx
+// is cancelled.
+func (c *Client) PostEventsContext(ctx context.Context, events []*Event) error {
+	n := c.EventConcurrency
+	if n < 1 {
+		n = 4
+	}
+
+	errs := make([]error, len(events))
+	sem := make(chan struct{}, n)
+
+	var wg sync.WaitGroup
+	for i, event := range events {
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, event *Event) {
+			defer func() { <-sem; wg.Done() }()
+			errs[i] = c.PostEventContext(ctx, event)
+		}(i, event)
+	}
+	wg.Wait()
+
+	failed := errs[:0]
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return newMultiError(failed)
+}
+
+// CreateEvent is like PostEvent, but returns the created event's ID and URL.
+func (c *Client) CreateEvent(event *Event) (*PostedEvent, error) {
+	return c.CreateEventContext(context.Background(), event)
+}
+
+// CreateEventContext is like CreateEvent, but aborts the request when ctx
+// is cancelled.
+func (c *Client) CreateEventContext(ctx context.Context, event *Event) (*PostedEvent, error) {
+	if event.Host == "" {
+		event.Host = c.Host
+	}
+
+	res := new(struct {
+		Event *PostedEvent `json:"event"`
+	})
+	if err := c.post(ctx, c.EventsUrl(), event, res); err != nil {
+		return nil, err
+	}
+	if res.Event == nil {
+		return new(PostedEvent), nil
+	}
+	return res.Event, nil
+}
+
+// QueryUrl gets an authenticated URL to query timeseries data from.
+func (c *Client) QueryUrl(from, to time.Time, query string) string {
+	return c.apiUrl("/query", url.Values{
+		"from":  {strconv.FormatInt(from.Unix(), 10)},
+		"to":    {strconv.FormatInt(to.Unix(), 10)},
+		"query": {query},
+	})
+}
+
+// QueryMetrics queries timeseries data between from and to. Requires `AppKey`.
+func (c *Client) QueryMetrics(from, to time.Time, query string) (*QueryResult, error) {
+	req, err := c.newAppRequest("GET", c.QueryUrl(from, to, query), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res := new(QueryResult)
+	if err := c.exchange(req, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DowntimeUrl gets an authenticated URL to manage downtimes. Pass an id of
+// 0 to address the collection.
+func (c *Client) DowntimeUrl(id int) string {
+	if id == 0 {
+		return c.apiUrl("/downtime", nil)
+	}
+	return c.apiUrl("/downtime/"+strconv.Itoa(id), nil)
+}
+
+// ScheduleDowntime schedules a monitor downtime and returns its id.
+// Requires `AppKey`.
+func (c *Client) ScheduleDowntime(d *Downtime) (int, error) {
+	body, err := c.marshal(d)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := c.newAppRequest("POST", c.DowntimeUrl(0), body)
+	if err != nil {
+		return 0, err
+	}
+
+	res := new(Downtime)
+	if err := c.exchange(req, res); err != nil {
+		return 0, err
+	}
+	return res.ID, nil
+}
+
+// CancelDowntime cancels a scheduled downtime. Requires `AppKey`.
+func (c *Client) CancelDowntime(id int) error {
+	req, err := c.newAppRequest("DELETE", c.DowntimeUrl(id), nil)
+	if err != nil {
+		return err
+	}
+	return c.exchange(req, nil)
+}
+
+// MetadataUrl gets an authenticated URL to manage metadata of the named metric.
+func (c *Client) MetadataUrl(name string) string {
+	return c.apiUrl("/metrics/"+url.PathEscape(name), nil)
+}
+
+// SetMetricMetadata updates the metadata of the named metric. Requires `AppKey`.
+func (c *Client) SetMetricMetadata(name string, meta *MetricMetadata) error {
+	body, err := c.marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newAppRequest("PUT", c.MetadataUrl(name), body)
+	if err != nil {
+		return err
+	}
+	return c.exchange(req, nil)
 }
 
 // Reporter creates a `MetricReporter`. The returned
@@ -78,6 +407,22 @@ func (c *Client) Reporter(tags ...string) *MetricReporter {
 	return NewReporter(c, tags...)
 }
 
+// Private gzip compression
+func (c *Client) compress(r io.Reader) (io.Reader, error) {
+	body := bytes.Buffer{}
+	zw := gzip.NewWriter(&body)
+	if _, err := io.Copy(zw, r); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return &body, nil
+}
+
+// Private host accessor, used by reporters
+func (c *Client) hostname() string { return c.Host }
+
 // Private marshal
 func (c *Client) marshal(v interface{}) (io.Reader, error) {
 	body := bytes.Buffer{}
@@ -87,21 +432,274 @@ func (c *Client) marshal(v interface{}) (io.Reader, error) {
 	return &body, nil
 }
 
+// Private URL builder, appends the API key to the query unless header
+// authentication is enabled
+func (c *Client) apiUrl(path string, query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+	if !c.UseHeaderAuth {
+		query.Set("api_key", c.ApiKey)
+	}
+	if len(query) == 0 {
+		return c.endpoint() + path
+	}
+	return c.endpoint() + path + "?" + query.Encode()
+}
+
+// Private endpoint accessor
+func (c *Client) endpoint() string {
+	if c.Endpoint != "" {
+		return strings.TrimSuffix(c.Endpoint, "/")
+	}
+	return ENDPOINT
+}
+
+// Private request builder, sets content type and auth headers and
+// compresses the body if required
+func (c *Client) newRequest(method, url string, body io.Reader) (*http.Request, error) {
+	if body != nil && c.Compress {
+		var err error
+		if body, err = c.compress(body); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, c.redact(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if body != nil && c.Compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if c.UseHeaderAuth {
+		req.Header.Set("DD-API-KEY", c.ApiKey)
+	}
+	return req, nil
+}
+
+// Private request builder for APIs which require an application key
+func (c *Client) newAppRequest(method, url string, body io.Reader) (*http.Request, error) {
+	if c.AppKey == "" {
+		return nil, errMissingAppKey
+	}
+
+	req, err := c.newRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("DD-APPLICATION-KEY", c.AppKey)
+	return req, nil
+}
+
+// Private HTTP round-trip, errors never contain the API key
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	if err := c.begin(); err != nil {
+		return nil, err
+	}
+	defer c.end()
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	c.record(req, resp, start)
+	if err != nil {
+		return nil, c.redact(err)
+	}
+	if rl := parseRateLimit(resp.Header); rl != nil {
+		c.rateLimitLock.Lock()
+		c.rateLimit = rl
+		c.rateLimitLock.Unlock()
+	}
+	return resp, nil
+}
+
+// RateLimitState returns the rate limit state reported with the most recent
+// response, or nil if no rate limit headers were received yet.
+func (c *Client) RateLimitState() *RateLimit {
+	c.rateLimitLock.Lock()
+	defer c.rateLimitLock.Unlock()
+	return c.rateLimit
+}
+
+// Private error sanitizer, strips the API key, raw or query escaped, from
+// errors. URL errors keep their type, other errors mentioning the key are
+// replaced by a plain error with the redacted message.
+func (c *Client) redact(err error) error {
+	if err == nil || c.ApiKey == "" {
+		return err
+	}
+	if uerr, ok := err.(*url.Error); ok {
+		uerr.URL = c.redactString(uerr.URL)
+		uerr.Err = c.redact(uerr.Err)
+		return uerr
+	}
+	if msg := err.Error(); c.redactString(msg) != msg {
+		return errors.New(c.redactString(msg))
+	}
+	return err
+}
+
+func (c *Client) redactString(s string) string {
+	s = strings.Replace(s, c.ApiKey, "REDACTED", -1)
+	return strings.Replace(s, url.QueryEscape(c.ApiKey), "REDACTED", -1)
+}
+
+// isRetryable returns true for rate-limited and server error responses
+func isRetryable(code int) bool {
+	return code == 429 || code >= 500
+}
+
+var errMissingAppKey = errors.New("Datadog application key required")
+
+var errClientClosed = errors.New("Datadog client closed")
+
+// Close waits for in-flight requests to complete and closes idle connections
+// of the HTTPClient. Without an HTTPClient, idle connections of
+// http.DefaultClient are closed, which it shares with other users in the
+// process. Subsequent requests fail. To deliver buffered series, flush or
+// drain reporters before closing the client.
+func (c *Client) Close() error {
+	return c.CloseContext(context.Background())
+}
+
+// CloseContext is like Close, but stops waiting for in-flight requests when
+// ctx is cancelled.
+func (c *Client) CloseContext(ctx context.Context) error {
+	c.closeLock.Lock()
+	c.closed = true
+	var idle chan struct{}
+	if c.inflight > 0 {
+		if c.idle == nil {
+			c.idle = make(chan struct{})
+		}
+		idle = c.idle
+	}
+	c.closeLock.Unlock()
+
+	if idle != nil {
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if c.HTTPClient != nil {
+		c.HTTPClient.CloseIdleConnections()
+	} else {
+		http.DefaultClient.CloseIdleConnections()
+	}
+	return nil
+}
+
+// Private in-flight request tracking
+func (c *Client) begin() error {
+	c.closeLock.Lock()
+	defer c.closeLock.Unlock()
+
+	if c.closed {
+		return errClientClosed
+	}
+	c.inflight++
+	return nil
+}
+
+func (c *Client) end() {
+	c.closeLock.Lock()
+	defer c.closeLock.Unlock()
+
+	c.inflight--
+	if c.inflight == 0 && c.idle != nil {
+		close(c.idle)
+		c.idle = nil
+	}
+}
+
 // Private HTTP post
-func (c *Client) post(url string, v interface{}) error {
+func (c *Client) post(ctx context.Context, url string, v, res interface{}) error {
 	body, err := c.marshal(v)
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.Post(url, "application/json", body)
+	req, err := c.newRequest("POST", url, body)
 	if err != nil {
 		return err
 	}
+	return c.exchange(req.WithContext(ctx), res)
+}
+
+// Private HTTP exchange, expects a 2xx response and decodes the JSON
+// body into v, unless v is nil. Failed attempts are retried. Errors never
+// contain the API key.
+func (c *Client) exchange(req *http.Request, v interface{}) error {
+	return c.redact(c.send(req, v))
+}
+
+// Bounds of the delay between retries
+const (
+	minRetryBackoff = 100 * time.Millisecond
+	maxRetryBackoff = 30 * time.Second
+)
+
+// Private retry loop of exchange
+func (c *Client) send(req *http.Request, v interface{}) error {
+	backoff := c.RetryBackoff
+	if backoff < minRetryBackoff {
+		backoff = minRetryBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := c.do(req)
+		if attempt >= c.Retries || (err == nil && !isRetryable(resp.StatusCode)) {
+			if err != nil {
+				return err
+			}
+			return c.decode(resp, v)
+		}
+		if err == nil {
+			resp.Body.Close()
+			if d := retryAfter(resp.Header); d > backoff {
+				backoff = d
+			}
+		}
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return req.Context().Err()
+		}
+		backoff *= 2
+
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Private response decoder
+func (c *Client) decode(resp *http.Response, v interface{}) error {
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 && resp.StatusCode != 202 {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		return fmt.Errorf("Bad Datadog response: '%s'", resp.Status)
 	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil && err != io.EOF {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,195 @@
+package datadog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const secretKey = "secret+key/=="
+
+func assertRedacted(t *testing.T, err error) {
+	t.Helper()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if msg := err.Error(); strings.Contains(msg, secretKey) || strings.Contains(msg, url.QueryEscape(secretKey)) {
+		t.Errorf("expected the API key to be redacted, got %q", msg)
+	}
+}
+
+func TestClientHeaderAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.String(), url.QueryEscape(secretKey)) {
+			t.Errorf("expected no API key in the URL, got %s", r.URL)
+		}
+		if r.Header.Get("DD-API-KEY") != secretKey || r.Header.Get("DD-APPLICATION-KEY") != "app" {
+			t.Errorf("expected API and application key headers, got %v", r.Header)
+		}
+		w.WriteHeader(400)
+	}))
+	defer srv.Close()
+
+	c := New("host", secretKey, WithEndpoint(srv.URL), WithHeaderAuth(), WithAppKey("app"))
+	_, err := c.QueryMetrics(time.Unix(0, 0), time.Unix(60, 0), "avg:a{*}")
+	assertRedacted(t, err)
+}
+
+func TestClientRedactsErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Close()
+
+	c := New("host", secretKey, WithEndpoint(srv.URL))
+	err := c.PostSeries([]*Series{NewSeries("a", 1, 2, nil, MT_GAUGE)})
+	assertRedacted(t, err)
+	if _, ok := err.(*url.Error); !ok {
+		t.Errorf("expected a URL error, got %T", err)
+	}
+
+	c = New("host", secretKey, WithEndpoint("http://\x7f"+secretKey))
+	assertRedacted(t, c.PostSeries(nil))
+}
+
+func TestClientRetries(t *testing.T) {
+	var attempts []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts = append(attempts, time.Now())
+		switch len(attempts) {
+		case 1:
+			w.WriteHeader(503)
+		case 2:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(429)
+		default:
+			w.WriteHeader(202)
+		}
+	}))
+	defer srv.Close()
+
+	c := New("host", "key", WithEndpoint(srv.URL), WithRetry(2, 0))
+	if err := c.PostSeries([]*Series{NewSeries("a", 1, 2, nil, MT_GAUGE)}); err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %v", err)
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(attempts))
+	}
+	if d := attempts[1].Sub(attempts[0]); d < minRetryBackoff {
+		t.Errorf("expected a minimum backoff of %v, got %v", minRetryBackoff, d)
+	}
+	if d := attempts[2].Sub(attempts[1]); d < time.Second {
+		t.Errorf("expected to wait for Retry-After, got %v", d)
+	}
+}
+
+func TestClientCloseAfterDrain(t *testing.T) {
+	var lock sync.Mutex
+	received := 0
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		lock.Lock()
+		received++
+		lock.Unlock()
+		w.WriteHeader(202)
+	}))
+	defer srv.Close()
+
+	c := New("host", "key", WithEndpoint(srv.URL))
+	rep := c.Reporter()
+	rep.QueueDepth = 5
+	RegisterCounter(rep, "c").Inc(1)
+	for i := 0; i < 3; i++ {
+		rep.Report()
+	}
+
+	drained := make(chan error)
+	go func() { drained <- rep.Drain(context.Background()) }()
+	select {
+	case <-drained:
+		t.Fatal("expected Drain to wait for queued reports")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-drained; err != nil {
+		t.Fatalf("expected queue to drain, got %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("expected Close to succeed, got %v", err)
+	}
+
+	lock.Lock()
+	n := received
+	lock.Unlock()
+	if n != 3 {
+		t.Errorf("expected all 3 queued reports to be delivered before Close, got %d", n)
+	}
+	if err := c.PostSeries(nil); err != errClientClosed {
+		t.Errorf("expected requests to fail after Close, got %v", err)
+	}
+}
+
+func TestClientValidate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/validate" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		switch r.URL.Query().Get("api_key") {
+		case "good":
+			w.Write([]byte(`{"valid":true}`))
+		case "broken":
+			w.WriteHeader(500)
+		default:
+			w.WriteHeader(403)
+		}
+	}))
+	defer srv.Close()
+
+	if ok, err := New("host", "good", WithEndpoint(srv.URL)).Validate(); !ok || err != nil {
+		t.Errorf("expected a valid key, got %v, %v", ok, err)
+	}
+	if ok, err := New("host", "bad", WithEndpoint(srv.URL)).Validate(); ok || err != nil {
+		t.Errorf("expected a rejected key without error, got %v, %v", ok, err)
+	}
+	if _, err := New("host", "broken", WithEndpoint(srv.URL)).Validate(); err == nil {
+		t.Error("expected an error for a server error")
+	}
+}
+
+func TestPostEventsConcurrency(t *testing.T) {
+	var lock sync.Mutex
+	inflight, peak, posted := 0, 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		inflight++
+		if inflight > peak {
+			peak = inflight
+		}
+		lock.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		lock.Lock()
+		inflight--
+		posted++
+		lock.Unlock()
+		w.WriteHeader(202)
+	}))
+	defer srv.Close()
+
+	events := make([]*Event, 10)
+	for i := range events {
+		events[i] = &Event{Title: "event"}
+	}
+	c := New("host", "key", WithEndpoint(srv.URL), WithEventConcurrency(2))
+	if err := c.PostEvents(events); err != nil {
+		t.Fatal(err)
+	}
+	if posted != 10 || peak > 2 {
+		t.Errorf("expected 10 events with at most 2 in flight, got %d with %d", posted, peak)
+	}
+}
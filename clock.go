@@ -0,0 +1,111 @@
+package datadog
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the source of time for rates, decaying samples and metric ticks.
+// It can be replaced by a FakeClock to test time-dependent behaviour
+// deterministically.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// Tick returns a channel delivering ticks at the interval d
+	Tick(d time.Duration) <-chan time.Time
+}
+
+// packageClock delegates to the clock set by SetClock. It is safe to swap
+// while metrics are being ticked or updated.
+type packageClock struct {
+	v atomic.Value // holds a clockValue
+}
+
+// clockValue wraps a Clock, as an atomic.Value must always hold the same type
+type clockValue struct{ Clock }
+
+var clock = new(packageClock)
+
+func (p *packageClock) load() Clock {
+	if c, ok := p.v.Load().(clockValue); ok {
+		return c.Clock
+	}
+	return realClock{}
+}
+
+func (p *packageClock) Now() time.Time                        { return p.load().Now() }
+func (p *packageClock) Tick(d time.Duration) <-chan time.Time { return p.load().Tick(d) }
+
+// SetClock replaces the package clock. Metrics which are already registered
+// are ticked by the new clock from then on. Pass nil to restore the real
+// clock.
+func SetClock(c Clock) {
+	if c == nil {
+		c = realClock{}
+	}
+	clock.v.Store(clockValue{c})
+	arbiter.restart()
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                        { return time.Now() }
+func (realClock) Tick(d time.Duration) <-chan time.Time { return time.NewTicker(d).C }
+
+// FakeClock is a manually advanced clock for testing
+type FakeClock struct {
+	now     time.Time
+	tickers []*fakeTicker
+	lock    sync.Mutex
+}
+
+type fakeTicker struct {
+	interval time.Duration
+	next     time.Time
+	c        chan time.Time
+}
+
+// NewFakeClock creates a fake clock, set to t
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{now: t}
+}
+
+// Now returns the fake time
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// Tick returns a channel delivering a tick each time the clock is advanced
+// past a multiple of d
+func (c *FakeClock) Tick(d time.Duration) <-chan time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t := &fakeTicker{interval: d, next: c.now.Add(d), c: make(chan time.Time)}
+	c.tickers = append(c.tickers, t)
+	return t.c
+}
+
+// Advance moves the clock forward by d. It blocks until all ticks which
+// became due have been received.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+
+	var due []chan time.Time
+	for _, t := range c.tickers {
+		for !t.next.After(now) {
+			due = append(due, t.c)
+			t.next = t.next.Add(t.interval)
+		}
+	}
+	c.lock.Unlock()
+
+	for _, ch := range due {
+		ch <- now
+	}
+}
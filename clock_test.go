@@ -0,0 +1,24 @@
+package datadog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetClockTicksRunningMeters(t *testing.T) {
+	m := NewMeter("started.before.fake.clock")
+	defer arbiter.remove(m)
+
+	fc := NewFakeClock(time.Unix(1000, 0))
+	SetClock(fc)
+	defer SetClock(nil)
+
+	m.Mark(10)
+	// the second tick is only received once the first one has been handled
+	fc.Advance(5 * time.Second)
+	fc.Advance(5 * time.Second)
+
+	if r := m.Rate1(); r <= 0 {
+		t.Errorf("expected the fake clock to tick the meter, got a rate of %v", r)
+	}
+}
@@ -0,0 +1,36 @@
+package datadog
+
+// CollectSeries flushes all metrics registered with rep and returns the
+// series which would be submitted by the next report, without submitting
+// them. It is intended for tests of instrumented code.
+func CollectSeries(rep *MetricReporter) []*Series {
+	return rep.marshallable(rep.Series())
+}
+
+// FindSeries returns the first series with the given name which carries all
+// of the given tags, or nil if there is none.
+func FindSeries(series []*Series, name string, tags ...string) *Series {
+	for _, s := range series {
+		if s.Metric == name && hasTags(s.Tags, tags) {
+			return s
+		}
+	}
+	return nil
+}
+
+// hasTags checks if all of want are included in tags
+func hasTags(tags, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, t := range tags {
+			if t == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
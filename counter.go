@@ -3,18 +3,48 @@ package datadog
 // Inspired by https://github.com/rcrowley/go-metrics
 // Copyright 2012 Richard Crowley. All rights reserved.
 
-import "sync/atomic"
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+)
 
 // Counter is the standard implementation of a Counter and uses the
 // sync/atomic package to manage a single int64 value.
 type Counter struct {
 	BaseMetric
+	activity
 	count int64
+
+	// AsRate flushes the increase since the previous flush as a per-second
+	// MT_RATE series, with the interval set to the elapsed time. This lets
+	// Datadog normalise correctly, regardless of the flush cadence.
+	AsRate bool
+
+	// SampleRate is the fraction of events counted, e.g. 0.1 if only every
+	// tenth event is counted to reduce overhead. Flushed values are scaled
+	// up by 1/SampleRate, which estimates the true count, but loses
+	// precision for rare events. Zero or one disables scaling.
+	SampleRate float64
+
+	// flushLock guards flushedCount and flushedAt, so both advance together
+	flushLock    sync.Mutex
+	flushedCount int64
+	flushedAt    int64
+	deltaCount   int64
 }
 
 // NewCounter creates a new counter
 func NewCounter(name string, tags ...string) *Counter {
-	return &Counter{BaseMetric: BaseMetric{name: name, tags: tags}}
+	return &Counter{
+		BaseMetric: BaseMetric{name: name, tags: tags},
+		flushedAt:  clock.Now().Unix(),
+	}
+}
+
+// NewCounterM is like NewCounter, but with tags converted by TagsFromMap
+func NewCounterM(name string, tags map[string]string) *Counter {
+	return NewCounter(name, TagsFromMap(tags)...)
 }
 
 // FetchCounter returns or registers a new one
@@ -29,9 +59,21 @@ func RegisterCounter(rep *MetricReporter, name string, tags ...string) *Counter
 	return m
 }
 
+func (m *Counter) withTags(name string, tags []string) Metric {
+	c := NewCounter(name, tags...)
+	c.AsRate, c.SampleRate = m.AsRate, m.SampleRate
+	return c
+}
+
 // Clear sets the counter to zero.
 func (c *Counter) Clear() {
+	c.flushLock.Lock()
 	atomic.StoreInt64(&c.count, 0)
+	c.flushedCount = 0
+	c.flushLock.Unlock()
+
+	atomic.StoreInt64(&c.deltaCount, 0)
+	c.touch()
 }
 
 // Count returns the current count.
@@ -39,21 +81,89 @@ func (c *Counter) Count() int64 {
 	return atomic.LoadInt64(&c.count)
 }
 
+// Delta returns the change of the count since the previous call to Delta,
+// e.g. for local decisions based on recent activity. Unlike a FlashCounter,
+// the count itself is not reset, so reported values are unaffected. Resets
+// of a FlashCounter on flush are reflected as negative deltas.
+func (c *Counter) Delta() int64 {
+	count := c.Count()
+	return count - atomic.SwapInt64(&c.deltaCount, count)
+}
+
 // Dec decrements the counter by the given amount.
 func (c *Counter) Dec(i int64) {
 	atomic.AddInt64(&c.count, -i)
+	c.touch()
 }
 
 // Inc increments the counter by the given amount.
 func (c *Counter) Inc(i int64) {
 	atomic.AddInt64(&c.count, i)
+	c.touch()
 }
 
+// Add increments the counter by the given amount, alias for Inc.
+func (c *Counter) Add(i int64) { c.Inc(i) }
+
 // Flush returns series
 func (m *Counter) Flush(now int64) []*Series {
+	m.untouch()
+	if m.AsRate {
+		m.flushLock.Lock()
+		count := m.Count()
+		interval, ok := m.interval(now)
+		delta := count - m.flushedCount
+		m.flushedCount = count
+		m.flushLock.Unlock()
+
+		if !ok {
+			return nil
+		}
+		return []*Series{m.rate(now, delta, interval)}
+	}
+	count := m.Count()
 	return []*Series{
-		NewSeries(m.name+".count", now, m.Count(), m.tags, MT_COUNTER),
+		NewSeries(m.name+".count", now, m.scaled(count), m.tags, MT_COUNTER),
+	}
+}
+
+// interval returns the seconds since the previous flush and starts a new
+// interval. Counters not created by NewCounter have no previous flush,
+// so their first interval starts on the first flush, reported as false.
+// The flush lock must be held.
+func (m *Counter) interval(now int64) (int64, bool) {
+	prev := m.flushedAt
+	m.flushedAt = now
+	return now - prev, prev != 0
+}
+
+// rate builds a rate series for delta events over interval seconds. A
+// decrease, e.g. by Dec, is reported as a zero rate.
+func (m *Counter) rate(now, delta, interval int64) *Series {
+	if interval < 1 {
+		interval = 1
+	}
+	if delta < 0 {
+		delta = 0
 	}
+
+	return NewRateSeries(m.name+".count", now, float64(delta)*sampleScale(m.SampleRate)/float64(interval), interval, m.tags)
+}
+
+// scaled scales a count by the sample rate, if set
+func (m *Counter) scaled(count int64) interface{} {
+	if scale := sampleScale(m.SampleRate); scale != 1 {
+		return float64(count) * scale
+	}
+	return count
+}
+
+// sampleScale returns the factor to scale sampled values by
+func sampleScale(rate float64) float64 {
+	if rate <= 0 || rate >= 1 {
+		return 1
+	}
+	return 1 / rate
 }
 
 // FlashCounter is the a counter that resets to 0 after each flush
@@ -63,7 +173,10 @@ type FlashCounter struct {
 
 // NewFlashCounter creates a new reset counter
 func NewFlashCounter(name string, tags ...string) *FlashCounter {
-	return &FlashCounter{*NewCounter(name, tags...)}
+	return &FlashCounter{Counter{
+		BaseMetric: BaseMetric{name: name, tags: tags},
+		flushedAt:  clock.Now().Unix(),
+	}}
 }
 
 // FetchFlashCounter returns or registers a new one
@@ -78,12 +191,155 @@ func RegisterFlashCounter(rep *MetricReporter, name string, tags ...string) *Fla
 	return m
 }
 
+func (m *FlashCounter) withTags(name string, tags []string) Metric {
+	c := NewFlashCounter(name, tags...)
+	c.AsRate, c.SampleRate = m.AsRate, m.SampleRate
+	return c
+}
+
 // Flush returns series and resets counter
 func (m *FlashCounter) Flush(now int64) []*Series {
+	m.untouch()
+	m.flushLock.Lock()
+	interval, ok := m.interval(now)
+	m.flushLock.Unlock()
+	if m.AsRate && !ok {
+		return nil
+	}
+
 	count := m.Count()
-	defer m.Dec(count)
+	defer atomic.AddInt64(&m.count, -count)
+
+	if m.AsRate {
+		return []*Series{m.rate(now, count, interval)}
+	}
+	return []*Series{
+		NewSeries(m.name+".count", now, m.scaled(count), m.tags, MT_COUNTER),
+	}
+}
+
+// CounterU is like a normal Counter, but holds unsigned values, so large
+// totals never overflow into negatives. It cannot be decremented.
+type CounterU struct {
+	BaseMetric
+	activity
+	count uint64
+}
+
+// NewCounterU creates a new counter
+func NewCounterU(name string, tags ...string) *CounterU {
+	return &CounterU{BaseMetric: BaseMetric{name: name, tags: tags}}
+}
+
+// FetchCounterU returns or registers a new one
+func FetchCounterU(rep *MetricReporter, name string, tags ...string) *CounterU {
+	return rep.Fetch(func() Metric { return NewCounterU(name, tags...) }, name, tags...).(*CounterU)
+}
+
+// RegisterCounterU registers a counter
+func RegisterCounterU(rep *MetricReporter, name string, tags ...string) *CounterU {
+	m := NewCounterU(name, tags...)
+	rep.Register(m)
+	return m
+}
+
+func (m *CounterU) withTags(name string, tags []string) Metric { return NewCounterU(name, tags...) }
+
+// Clear sets the counter to zero.
+func (c *CounterU) Clear() {
+	atomic.StoreUint64(&c.count, 0)
+	c.touch()
+}
+
+// Count returns the current count.
+func (c *CounterU) Count() uint64 {
+	return atomic.LoadUint64(&c.count)
+}
+
+// Inc increments the counter by the given amount.
+func (c *CounterU) Inc(i uint64) {
+	atomic.AddUint64(&c.count, i)
+	c.touch()
+}
+
+// Add increments the counter by the given amount, alias for Inc.
+func (c *CounterU) Add(i uint64) { c.Inc(i) }
 
+// Flush returns series
+func (m *CounterU) Flush(now int64) []*Series {
+	m.untouch()
 	return []*Series{
-		NewSeries(m.name+".count", now, count, m.tags, MT_COUNTER),
+		NewSeries(m.name+".count", now, m.Count(), m.tags, MT_COUNTER),
+	}
+}
+
+// MonotonicCounter is a counter which can never be decremented. Attempts to
+// decrement are ignored and logged once, so accidental misuse doesn't
+// corrupt dashboards. Use a Counter for gauge-like values.
+type MonotonicCounter struct {
+	Counter
+	warning sync.Once
+}
+
+// NewMonotonicCounter creates a new monotonic counter
+func NewMonotonicCounter(name string, tags ...string) *MonotonicCounter {
+	return &MonotonicCounter{Counter: Counter{
+		BaseMetric: BaseMetric{name: name, tags: tags},
+		flushedAt:  clock.Now().Unix(),
+	}}
+}
+
+// FetchMonotonicCounter returns or registers a new one
+func FetchMonotonicCounter(rep *MetricReporter, name string, tags ...string) *MonotonicCounter {
+	return rep.Fetch(func() Metric { return NewMonotonicCounter(name, tags...) }, name, tags...).(*MonotonicCounter)
+}
+
+// RegisterMonotonicCounter registers a monotonic counter
+func RegisterMonotonicCounter(rep *MetricReporter, name string, tags ...string) *MonotonicCounter {
+	m := NewMonotonicCounter(name, tags...)
+	rep.Register(m)
+	return m
+}
+
+func (m *MonotonicCounter) withTags(name string, tags []string) Metric {
+	c := NewMonotonicCounter(name, tags...)
+	c.AsRate, c.SampleRate = m.AsRate, m.SampleRate
+	return c
+}
+
+// Dec ignores positive amounts, as monotonic counters cannot be decremented.
+func (c *MonotonicCounter) Dec(i int64) {
+	if i > 0 {
+		c.warn()
+	} else {
+		c.Counter.Inc(-i)
+	}
+}
+
+// Inc increments the counter by the given amount. Negative amounts are ignored.
+func (c *MonotonicCounter) Inc(i int64) {
+	if i < 0 {
+		c.warn()
+	} else {
+		c.Counter.Inc(i)
 	}
 }
+
+// Add increments the counter by the given amount, alias for Inc.
+func (c *MonotonicCounter) Add(i int64) { c.Inc(i) }
+
+func (c *MonotonicCounter) warn() {
+	c.warning.Do(func() {
+		log.Printf("Datadog monotonic counter %s cannot be decremented, ignoring", c.name)
+	})
+}
+
+// SnapshotValues returns the current count, without flushing.
+func (c *Counter) SnapshotValues() map[string]interface{} {
+	return map[string]interface{}{"count": c.Count()}
+}
+
+// SnapshotValues returns the current count, without flushing.
+func (c *CounterU) SnapshotValues() map[string]interface{} {
+	return map[string]interface{}{"count": c.Count()}
+}
@@ -0,0 +1,98 @@
+package datadog
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCounterAsRateConcurrentFlush(t *testing.T) {
+	c := NewCounter("counter")
+	c.AsRate = true
+	now := time.Now().Unix()
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		total float64
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.Inc(1)
+				s := c.Flush(now)[0]
+				mu.Lock()
+				total += s.Points[0][1].(float64) * float64(s.Interval)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if total != 400 {
+		t.Errorf("expected rates to add up to 400 events, got %v", total)
+	}
+}
+
+func TestCounterAsRateInterval(t *testing.T) {
+	fc := NewFakeClock(time.Unix(1000, 0))
+	SetClock(fc)
+	defer SetClock(nil)
+
+	c := NewCounter("counter")
+	c.AsRate = true
+	c.Inc(20)
+	s := c.Flush(1010)[0]
+	if s.Interval != 10 || s.Points[0][1].(float64) != 2 {
+		t.Errorf("expected rate of 2 over 10s, got %v over %ds", s.Points[0][1], s.Interval)
+	}
+
+	// counters not created by NewCounter start on the first flush
+	z := &FlashCounter{Counter{BaseMetric: BaseMetric{name: "zero"}, AsRate: true}}
+	z.Inc(10)
+	if series := z.Flush(1000); series != nil {
+		t.Errorf("expected first flush to start the interval, got %d series", len(series))
+	}
+	s = z.Flush(1005)[0]
+	if s.Interval != 5 || s.Points[0][1].(float64) != 2 {
+		t.Errorf("expected rate of 2 over 5s, got %v over %ds", s.Points[0][1], s.Interval)
+	}
+}
+
+func TestCounterAsRateClearAndDec(t *testing.T) {
+	fc := NewFakeClock(time.Unix(1000, 0))
+	SetClock(fc)
+	defer SetClock(nil)
+
+	c := NewCounter("counter")
+	c.AsRate = true
+	c.Inc(20)
+	c.Flush(1010)
+
+	c.Clear()
+	c.Inc(10)
+	if s := c.Flush(1020)[0]; s.Points[0][1].(float64) != 1 {
+		t.Errorf("expected rate of 1 after clear, got %v", s.Points[0][1])
+	}
+
+	c.Dec(5)
+	if s := c.Flush(1030)[0]; s.Points[0][1].(float64) != 0 {
+		t.Errorf("expected decrease to report a zero rate, got %v", s.Points[0][1])
+	}
+}
+
+func TestCounterSampleRate(t *testing.T) {
+	c := NewCounter("sampled")
+	c.SampleRate = 0.1
+	c.Inc(5)
+	if v := c.Flush(1000)[0].Points[0][1]; v != 50.0 {
+		t.Errorf("expected count scaled to 50, got %v", v)
+	}
+
+	c.SampleRate = 1
+	if v := c.Flush(1000)[0].Points[0][1]; v != int64(5) {
+		t.Errorf("expected unscaled count of 5, got %v", v)
+	}
+}
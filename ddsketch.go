@@ -0,0 +1,393 @@
+package datadog
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Defaults of DDSketchSample
+const (
+	defaultSketchAccuracy = 0.01
+	defaultSketchMaxBins  = 2048
+	sketchSnapshotSize    = 4096
+)
+
+var errSketchMismatch = errors.New("Datadog sketches with different accuracy cannot be merged")
+
+// DDSketchSample is a mergeable quantile sketch after DDSketch, with values
+// counted into logarithmically sized bins. Quantiles have a relative error of
+// at most the configured accuracy, e.g. 1%, regardless of the distribution.
+// Sketches with the same accuracy can be merged, e.g. across hosts.
+//
+// Once more than the maximum number of bins are in use, the lowest bins are
+// collapsed, trading accuracy of the smallest values for bounded memory.
+//
+// <https://arxiv.org/abs/1908.10693>
+type DDSketchSample struct {
+	accuracy, gamma, lnGamma float64
+	maxBins                  int
+
+	mutex sync.Mutex
+	count int64
+	zeros int64
+	pos   sketchBins
+	neg   sketchBins
+}
+
+// sketchBins counts values by bin index, keeping the indexes sorted, so
+// bins can be collapsed and listed without sorting
+type sketchBins struct {
+	counts map[int]int64
+	keys   []int
+}
+
+func newSketchBins() sketchBins {
+	return sketchBins{counts: make(map[int]int64)}
+}
+
+// add adds n values to bin i
+func (b *sketchBins) add(i int, n int64) {
+	if _, ok := b.counts[i]; !ok {
+		j := sort.SearchInts(b.keys, i)
+		b.keys = append(b.keys, 0)
+		copy(b.keys[j+1:], b.keys[j:])
+		b.keys[j] = i
+	}
+	b.counts[i] += n
+}
+
+// collapseLowest merges the lowest bin into the next one
+func (b *sketchBins) collapseLowest() {
+	b.counts[b.keys[1]] += b.counts[b.keys[0]]
+	delete(b.counts, b.keys[0])
+	b.keys = b.keys[1:]
+}
+
+func (b *sketchBins) copy() sketchBins {
+	cp := sketchBins{counts: make(map[int]int64, len(b.counts)), keys: append([]int(nil), b.keys...)}
+	for i, n := range b.counts {
+		cp.counts[i] = n
+	}
+	return cp
+}
+
+// SketchBucket is a bin of a DDSketchSample. The bin contains values up to
+// the inclusive Upper bound, represented by Value.
+type SketchBucket struct {
+	Value, Upper float64
+	Count        int64
+}
+
+// NewDDSketchSample creates a new sketch with the given relative accuracy,
+// e.g. 0.01 for 1%, and up to 2048 bins.
+func NewDDSketchSample(accuracy float64) *DDSketchSample {
+	return NewDDSketchSampleWithBins(accuracy, defaultSketchMaxBins)
+}
+
+// NewDDSketchSampleWithBins is like NewDDSketchSample, but with a custom
+// maximum number of bins.
+func NewDDSketchSampleWithBins(accuracy float64, maxBins int) *DDSketchSample {
+	if accuracy <= 0 || accuracy >= 1 {
+		accuracy = defaultSketchAccuracy
+	}
+	if maxBins < 2 {
+		maxBins = 2
+	}
+	gamma := (1 + accuracy) / (1 - accuracy)
+	return &DDSketchSample{
+		accuracy: accuracy,
+		gamma:    gamma,
+		lnGamma:  math.Log(gamma),
+		maxBins:  maxBins,
+		pos:      newSketchBins(),
+		neg:      newSketchBins(),
+	}
+}
+
+// Accuracy returns the relative accuracy of the sketch
+func (s *DDSketchSample) Accuracy() float64 { return s.accuracy }
+
+// Clear clears all samples.
+func (s *DDSketchSample) Clear() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reset()
+}
+
+// Count returns the number of values recorded.
+func (s *DDSketchSample) Count() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.count
+}
+
+// Size returns the number of values in a snapshot, at most 4096.
+func (s *DDSketchSample) Size() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.count > sketchSnapshotSize {
+		return sketchSnapshotSize
+	}
+	return int(s.count)
+}
+
+// Update records a new value.
+func (s *DDSketchSample) Update(v int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.count++
+	switch {
+	case v > 0:
+		s.pos.add(s.index(float64(v)), 1)
+	case v < 0:
+		s.neg.add(s.index(-float64(v)), 1)
+	default:
+		s.zeros++
+	}
+	s.collapse()
+}
+
+// Merge adds all values of o to the sketch. Both sketches must have the
+// same accuracy.
+func (s *DDSketchSample) Merge(o *DDSketchSample) error {
+	if s == o {
+		return nil
+	}
+	if s.gamma != o.gamma {
+		return errSketchMismatch
+	}
+
+	o.mutex.Lock()
+	count, zeros := o.count, o.zeros
+	pos, neg := o.pos.copy(), o.neg.copy()
+	o.mutex.Unlock()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.count += count
+	s.zeros += zeros
+	for _, i := range pos.keys {
+		s.pos.add(i, pos.counts[i])
+	}
+	for _, i := range neg.keys {
+		s.neg.add(i, neg.counts[i])
+	}
+	s.collapse()
+	return nil
+}
+
+// Buckets returns all non-empty bins, sorted by value.
+func (s *DDSketchSample) Buckets() []SketchBucket {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.buckets()
+}
+
+// Quantile returns the value at quantile q, e.g. 0.99, within the relative
+// accuracy of the sketch.
+func (s *DDSketchSample) Quantile(q float64) float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.count == 0 {
+		return 0
+	}
+	rank := int64(q * float64(s.count-1))
+	var seen int64
+	buckets := s.buckets()
+	for _, b := range buckets {
+		if seen += b.Count; seen > rank {
+			return b.Value
+		}
+	}
+	return buckets[len(buckets)-1].Value
+}
+
+// Snapshot creates a read-only snapshot for statistical analysis. Snapshots
+// hold the representative value of each bin, scaled down to at most 4096
+// values once more values were recorded.
+func (s *DDSketchSample) Snapshot() *SampleSnapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return NewSampleSnapshot(s.count, s.values())
+}
+
+// Values returns the representative values of the sketch, see Snapshot.
+func (s *DDSketchSample) Values() []int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.values()
+}
+
+// drain returns the number of values and their representatives, see
+// Snapshot, and clears the sketch
+func (s *DDSketchSample) drain() (int64, []float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count, values := s.count, s.representatives()
+	s.reset()
+	return count, values
+}
+
+// reset clears the sketch. The mutex must be held.
+func (s *DDSketchSample) reset() {
+	s.count, s.zeros = 0, 0
+	s.pos = newSketchBins()
+	s.neg = newSketchBins()
+}
+
+// index returns the index of the bin for v > 0
+func (s *DDSketchSample) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.lnGamma))
+}
+
+// bound returns the upper bound of bin i
+func (s *DDSketchSample) bound(i int) float64 {
+	return math.Pow(s.gamma, float64(i))
+}
+
+// collapse merges the lowest bins until at most maxBins are in use. The
+// mutex must be held.
+func (s *DDSketchSample) collapse() {
+	for len(s.pos.keys)+len(s.neg.keys) > s.maxBins {
+		// collapse negative bins closest to zero first, then the lowest
+		// positive ones
+		if len(s.neg.keys) > 1 {
+			s.neg.collapseLowest()
+		} else {
+			s.pos.collapseLowest()
+		}
+	}
+}
+
+// buckets returns all non-empty bins. The mutex must be held.
+func (s *DDSketchSample) buckets() []SketchBucket {
+	buckets := make([]SketchBucket, 0, len(s.pos.keys)+len(s.neg.keys)+1)
+
+	for j := len(s.neg.keys) - 1; j > -1; j-- {
+		i := s.neg.keys[j]
+		buckets = append(buckets, SketchBucket{
+			Value: -2 * s.bound(i) / (s.gamma + 1),
+			Upper: -s.bound(i - 1),
+			Count: s.neg.counts[i],
+		})
+	}
+	if s.zeros != 0 {
+		buckets = append(buckets, SketchBucket{Count: s.zeros})
+	}
+	for _, i := range s.pos.keys {
+		buckets = append(buckets, SketchBucket{
+			Value: 2 * s.bound(i) / (s.gamma + 1),
+			Upper: s.bound(i),
+			Count: s.pos.counts[i],
+		})
+	}
+	return buckets
+}
+
+// values returns the rounded representatives of the bins. The mutex must be
+// held.
+func (s *DDSketchSample) values() []int64 {
+	reps := s.representatives()
+	values := make([]int64, len(reps))
+	for i, v := range reps {
+		values[i] = int64(math.Round(v))
+	}
+	return values
+}
+
+// representatives expands the bins into representative values, scaled down
+// to at most sketchSnapshotSize. The mutex must be held.
+func (s *DDSketchSample) representatives() []float64 {
+	size := s.count
+	if size > sketchSnapshotSize {
+		size = sketchSnapshotSize
+	}
+
+	values := make([]float64, 0, size)
+	var seen, emitted int64
+	for _, b := range s.buckets() {
+		seen += b.Count
+		// round cumulatively, so the scaled distribution stays intact
+		target := int64(math.Round(float64(seen) * float64(size) / float64(s.count)))
+		for ; emitted < target; emitted++ {
+			values = append(values, b.Value)
+		}
+	}
+	return values
+}
+
+// Sketch is a metric backed by a DDSketchSample. On flush, the values
+// recorded since the previous flush are submitted as a single
+// MT_DISTRIBUTION series of the bins' representative values, so Datadog
+// can compute global quantiles within the sketch's relative accuracy.
+// Series per bin would create a context per bound and tag set instead.
+// Beyond 4096 values per flush, representatives are scaled down like a
+// Snapshot, so the exact number of values is flushed as a `<name>.count`
+// counter.
+type Sketch struct {
+	BaseMetric
+	activity
+	sample *DDSketchSample
+}
+
+// NewSketch creates a new sketch with the given relative accuracy
+func NewSketch(name string, accuracy float64, tags ...string) *Sketch {
+	return &Sketch{BaseMetric: BaseMetric{name: name, tags: tags}, sample: NewDDSketchSample(accuracy)}
+}
+
+// FetchSketch returns or registers a new one
+func FetchSketch(rep *MetricReporter, name string, accuracy float64, tags ...string) *Sketch {
+	return rep.Fetch(func() Metric { return NewSketch(name, accuracy, tags...) }, name, tags...).(*Sketch)
+}
+
+// RegisterSketch registers a sketch
+func RegisterSketch(rep *MetricReporter, name string, accuracy float64, tags ...string) *Sketch {
+	m := NewSketch(name, accuracy, tags...)
+	rep.Register(m)
+	return m
+}
+
+func (m *Sketch) withTags(name string, tags []string) Metric {
+	sample, ok := newSampleLike(m.sample).(*DDSketchSample)
+	if !ok {
+		sample = NewDDSketchSampleWithBins(m.sample.accuracy, m.sample.maxBins)
+	}
+	return &Sketch{BaseMetric: BaseMetric{name: name, tags: tags}, sample: sample}
+}
+
+// Sample returns the underlying sketch, e.g. to merge or query it
+func (m *Sketch) Sample() *DDSketchSample { return m.sample }
+
+// Clear clears the sketch.
+func (m *Sketch) Clear() { m.sample.Clear() }
+
+// Update records a new value.
+func (m *Sketch) Update(v int64) {
+	m.sample.Update(v)
+	m.touch()
+}
+
+// Flush returns series and resets the sketch
+func (m *Sketch) Flush(now int64) []*Series {
+	m.untouch()
+
+	count, values := m.sample.drain()
+	if count == 0 {
+		return nil
+	}
+	return []*Series{
+		NewSeries(m.name, now, values, m.tags, MT_DISTRIBUTION),
+		NewSeries(m.name+".count", now, count, m.tags, MT_COUNTER),
+	}
+}
+
+// SnapshotValues returns the current sketch stats, without flushing.
+func (m *Sketch) SnapshotValues() map[string]interface{} {
+	return sampleValues(make(map[string]interface{}, 9), m.sample, nil, identity)
+}
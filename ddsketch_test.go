@@ -0,0 +1,88 @@
+package datadog
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+func TestDDSketchSampleQuantiles(t *testing.T) {
+	s := NewDDSketchSample(0.01)
+	o := NewDDSketchSample(0.01)
+	for i := int64(1); i <= 10000; i++ {
+		if i%2 == 0 {
+			s.Update(i)
+		} else {
+			o.Update(i)
+		}
+	}
+	if err := s.Merge(o); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		if got, want := s.Quantile(q), q*9999+1; math.Abs(got-want)/want > 0.011 {
+			t.Errorf("quantile %v: expected %v, got %v", q, want, got)
+		}
+	}
+	if snap := s.Snapshot(); snap.Count() != 10000 || snap.Size() != sketchSnapshotSize {
+		t.Errorf("expected snapshot of %d values, got %d of %d", sketchSnapshotSize, snap.Size(), snap.Count())
+	}
+	if err := s.Merge(NewDDSketchSample(0.02)); err == nil {
+		t.Error("expected sketches with different accuracy not to merge")
+	}
+}
+
+func TestDDSketchSampleCollapse(t *testing.T) {
+	s := NewDDSketchSampleWithBins(0.01, 10)
+	for i := int64(1); i < 100000; i *= 2 {
+		s.Update(i)
+		s.Update(-i)
+	}
+
+	buckets := s.Buckets()
+	if len(buckets) != 10 {
+		t.Fatalf("expected 10 bins, got %d", len(buckets))
+	}
+	if !sort.SliceIsSorted(buckets, func(i, j int) bool { return buckets[i].Value < buckets[j].Value }) {
+		t.Errorf("expected bins to be sorted, got %v", buckets)
+	}
+	var n int64
+	for _, b := range buckets {
+		n += b.Count
+	}
+	if n != s.Count() {
+		t.Errorf("expected collapsed bins to keep all %d values, got %d", s.Count(), n)
+	}
+}
+
+func TestSketchFlush(t *testing.T) {
+	m := NewSketch("sketch", 0.01)
+	for i := 0; i < 5000; i++ {
+		m.Update(3)
+	}
+	m.Update(100)
+
+	series := m.Flush(1)
+	if len(series) != 2 {
+		t.Fatalf("expected 2 series, got %d", len(series))
+	}
+	if s := series[0]; s.Type != MT_DISTRIBUTION || len(s.Points[0][1].([]float64)) != sketchSnapshotSize {
+		t.Errorf("expected distribution of %d values, got %s %v", sketchSnapshotSize, s.Type, s.Points[0][1])
+	}
+	if s := series[1]; s.Metric != "sketch.count" || s.Points[0][1].(int64) != 5001 {
+		t.Errorf("expected exact count, got %s %v", s.Metric, s.Points[0][1])
+	}
+	if series := m.Flush(2); len(series) != 0 {
+		t.Errorf("expected sketch to be reset, got %d series", len(series))
+	}
+}
+
+func TestTaggedSketch(t *testing.T) {
+	rep := NewReporter(nil)
+	base := &Sketch{BaseMetric: BaseMetric{name: "sketch"}, sample: NewDDSketchSampleWithBins(0.02, 16)}
+
+	s := rep.Tagged(base, "a:b").(*Sketch).Sample()
+	if s.Accuracy() != 0.02 || s.maxBins != 16 {
+		t.Errorf("expected accuracy and bins to be kept, got %v and %d", s.Accuracy(), s.maxBins)
+	}
+}
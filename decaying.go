@@ -0,0 +1,98 @@
+package datadog
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// DecayingCounter is a counter which exponentially decays towards zero,
+// halving its value every half-life. It is flushed as a gauge and gives a
+// smooth signal of recent activity, e.g. of recent errors. Decay is applied
+// on each arbiter tick.
+type DecayingCounter struct {
+	BaseMetric
+	halfLife time.Duration
+
+	lock   sync.Mutex
+	value  float64
+	tickAt time.Time
+}
+
+// NewDecayingCounter creates a new decaying counter
+func NewDecayingCounter(name string, halfLife time.Duration, tags ...string) *DecayingCounter {
+	c := &DecayingCounter{
+		BaseMetric: BaseMetric{name: name, tags: tags},
+		halfLife:   halfLife,
+		tickAt:     clock.Now(),
+	}
+	arbiter.add(c)
+	return c
+}
+
+// FetchDecayingCounter returns or registers a new one
+func FetchDecayingCounter(rep *MetricReporter, name string, halfLife time.Duration, tags ...string) *DecayingCounter {
+	return rep.Fetch(func() Metric { return NewDecayingCounter(name, halfLife, tags...) }, name, tags...).(*DecayingCounter)
+}
+
+// RegisterDecayingCounter registers a decaying counter
+func RegisterDecayingCounter(rep *MetricReporter, name string, halfLife time.Duration, tags ...string) *DecayingCounter {
+	m := NewDecayingCounter(name, halfLife, tags...)
+	rep.Register(m)
+	return m
+}
+
+func (c *DecayingCounter) withTags(name string, tags []string) Metric {
+	return NewDecayingCounter(name, c.halfLife, tags...)
+}
+
+// HalfLife returns the counter's half-life
+func (c *DecayingCounter) HalfLife() time.Duration { return c.halfLife }
+
+// Inc increments the counter by the given amount.
+func (c *DecayingCounter) Inc(i int64) {
+	c.lock.Lock()
+	c.value += float64(i)
+	c.lock.Unlock()
+}
+
+// Add increments the counter by the given amount, alias for Inc.
+func (c *DecayingCounter) Add(i int64) { c.Inc(i) }
+
+// Clear sets the counter to zero.
+func (c *DecayingCounter) Clear() {
+	c.lock.Lock()
+	c.value = 0
+	c.lock.Unlock()
+}
+
+// Value returns the current decayed value.
+func (c *DecayingCounter) Value() float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.value
+}
+
+// Flush returns series
+func (c *DecayingCounter) Flush(now int64) []*Series {
+	return []*Series{
+		NewSeries(c.name+".value", now, c.Value(), c.tags, MT_GAUGE),
+	}
+}
+
+func (c *DecayingCounter) tick() {
+	now := clock.Now()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elapsed := now.Sub(c.tickAt); elapsed > 0 && c.halfLife > 0 {
+		c.value *= math.Exp2(-float64(elapsed) / float64(c.halfLife))
+	}
+	c.tickAt = now
+}
+
+// SnapshotValues returns the current decayed value.
+func (c *DecayingCounter) SnapshotValues() map[string]interface{} {
+	return map[string]interface{}{"value": c.Value()}
+}
@@ -0,0 +1,133 @@
+package datadog
+
+import (
+	"math"
+	"strconv"
+	"sync"
+)
+
+// Distribution collects raw observations and submits them to Datadog, which
+// aggregates them across all hosts. Unlike client-side percentiles from a
+// Histogram, this yields correct fleet-wide quantiles. Datadog computes the
+// `avg`, `count`, `max`, `min`, `sum` and percentile aggregations itself.
+//
+// Alternatively, when Buckets are set, observations are counted into buckets
+// instead and flushed as `<name>.bucket` counters tagged with
+// `upper_bound:<bound>`, with a final `upper_bound:+Inf` catch-all. Bucket
+// counts can be summed across hosts to approximate global quantiles.
+//
+// The Datadog agent's own histogram type instead emits per-host
+// `<name>.avg`, `<name>.count`, `<name>.median`, `<name>.95percentile`
+// and `<name>.max` series, which cannot be combined across hosts.
+type Distribution struct {
+	BaseMetric
+	activity
+
+	// Buckets are sorted, inclusive upper bounds for bucketed mode.
+	// They must be set before the first update.
+	Buckets []float64
+
+	lock   sync.Mutex
+	values []float64
+	counts []int64
+
+	// totals and sum accumulate bucketed observations across flushes
+	totals []int64
+	sum    float64
+}
+
+// NewDistribution creates a new distribution
+func NewDistribution(name string, tags ...string) *Distribution {
+	return &Distribution{BaseMetric: BaseMetric{name: name, tags: tags}}
+}
+
+// FetchDistribution returns or registers a new one
+func FetchDistribution(rep *MetricReporter, name string, tags ...string) *Distribution {
+	return rep.Fetch(func() Metric { return NewDistribution(name, tags...) }, name, tags...).(*Distribution)
+}
+
+// RegisterDistribution registers a distribution
+func RegisterDistribution(rep *MetricReporter, name string, tags ...string) *Distribution {
+	m := NewDistribution(name, tags...)
+	rep.Register(m)
+	return m
+}
+
+func (d *Distribution) withTags(name string, tags []string) Metric {
+	dd := NewDistribution(name, tags...)
+	dd.Buckets = append([]float64(nil), d.Buckets...)
+	return dd
+}
+
+// Update records a new observation. NaN is ignored, as are infinite values
+// unless counted into buckets.
+func (d *Distribution) Update(v float64) {
+	if math.IsNaN(v) || (len(d.Buckets) == 0 && math.IsInf(v, 0)) {
+		return
+	}
+
+	d.lock.Lock()
+	if len(d.Buckets) == 0 {
+		d.values = append(d.values, v)
+	} else {
+		if d.counts == nil {
+			d.counts = make([]int64, len(d.Buckets)+1)
+		}
+		if d.totals == nil {
+			d.totals = make([]int64, len(d.Buckets)+1)
+		}
+		i := 0
+		for i < len(d.Buckets) && v > d.Buckets[i] {
+			i++
+		}
+		d.counts[i]++
+		d.totals[i]++
+		d.sum += v
+	}
+	d.lock.Unlock()
+	d.touch()
+}
+
+// Flush returns series and resets all observations.
+func (d *Distribution) Flush(now int64) []*Series {
+	d.untouch()
+
+	d.lock.Lock()
+	values, counts := d.values, d.counts
+	d.values, d.counts = nil, nil
+	d.lock.Unlock()
+
+	if len(d.Buckets) == 0 {
+		if len(values) == 0 {
+			return nil
+		}
+		return []*Series{NewSeries(d.name, now, values, d.tags, MT_DISTRIBUTION)}
+	}
+
+	series := make([]*Series, 0, len(d.Buckets)+1)
+	for i := 0; i <= len(d.Buckets); i++ {
+		bound := "+Inf"
+		if i < len(d.Buckets) {
+			bound = strconv.FormatFloat(d.Buckets[i], 'g', -1, 64)
+		}
+		var n int64
+		if counts != nil {
+			n = counts[i]
+		}
+		series = append(series, NewSeries(d.name+".bucket", now, n, joinTags(d.tags, "upper_bound:"+bound), MT_COUNTER))
+	}
+	return series
+}
+
+// SnapshotValues returns the number of observations since the previous
+// flush, without flushing.
+func (d *Distribution) SnapshotValues() map[string]interface{} {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	n := int64(len(d.values))
+	for _, c := range d.counts {
+		n += c
+	}
+	return map[string]interface{}{"count": n}
+}
@@ -0,0 +1,34 @@
+package datadog
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestDistributionNonFinite(t *testing.T) {
+	d := NewDistribution("dist")
+	d.Update(1)
+	d.Update(math.NaN())
+	d.Update(math.Inf(1))
+	d.Update(2)
+
+	series := d.Flush(1)
+	if len(series) != 1 {
+		t.Fatalf("expected 1 series, got %d", len(series))
+	}
+	if vs := series[0].Points[0][1].([]float64); len(vs) != 2 {
+		t.Errorf("expected non-finite values to be ignored, got %v", vs)
+	}
+}
+
+func TestSeriesMarshalNonFiniteDistribution(t *testing.T) {
+	s := NewSeries("dist", 1, []float64{1, math.NaN(), math.Inf(-1), 2}, nil, MT_DISTRIBUTION)
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"metric":"dist","points":[[1,[1,2]]],"type":"distribution"}`; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+}
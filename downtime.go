@@ -0,0 +1,16 @@
+package datadog
+
+type Downtime struct {
+	// ID is assigned by Datadog when the downtime is scheduled
+	ID int `json:"id,omitempty"`
+	// The scopes to apply the downtime to, e.g. "host:app2" or "env:prod"
+	Scope []string `json:"scope"`
+	// Optional monitor to silence, defaults to all monitors
+	MonitorID int `json:"monitor_id,omitempty"`
+	// POSIX timestamp to start the downtime, defaults to now
+	Start int64 `json:"start,omitempty"`
+	// POSIX timestamp to end the downtime, defaults to never
+	End int64 `json:"end,omitempty"`
+	// A message to include with notifications for this downtime
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,39 @@
+package datadog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScheduleAndCancelDowntime(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Header.Get("DD-APPLICATION-KEY") != "app" {
+			t.Error("expected the application key header")
+		}
+		if r.Method == "POST" {
+			var d Downtime
+			if err := json.NewDecoder(r.Body).Decode(&d); err != nil || len(d.Scope) != 1 || d.Scope[0] != "env:prod" {
+				t.Errorf("unexpected downtime %+v, %v", d, err)
+			}
+			w.Write([]byte(`{"id":42,"scope":["env:prod"]}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := New("host", "key", WithEndpoint(srv.URL), WithAppKey("app"))
+	id, err := c.ScheduleDowntime(&Downtime{Scope: []string{"env:prod"}, Message: "deploy"})
+	if err != nil || id != 42 {
+		t.Fatalf("expected downtime 42, got %d, %v", id, err)
+	}
+	if err := c.CancelDowntime(id); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(requests) != 2 || requests[0] != "POST /downtime" || requests[1] != "DELETE /downtime/42" {
+		t.Errorf("unexpected requests %q", requests)
+	}
+}
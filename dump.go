@@ -0,0 +1,47 @@
+package datadog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Dump writes a human-readable line with the current state of each registered
+// metric to w, sorted by name. Metrics are not flushed, so dumping has no side
+// effects on reported data.
+func (rep *MetricReporter) Dump(w io.Writer) error {
+	mets := rep.registered()
+	sort.Slice(mets, func(i, j int) bool {
+		return NewMetricID(mets[i].Name(), mets[i].Tags()) < NewMetricID(mets[j].Name(), mets[j].Tags())
+	})
+
+	for _, m := range mets {
+		if _, err := fmt.Fprintf(w, "%s [%s] %s\n", m.Name(), strings.Join(m.Tags(), ","), dumpValues(m)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpValues formats the values of metrics implementing ValuesSnapshotter,
+// sorted by key
+func dumpValues(m Metric) string {
+	vs, ok := m.(ValuesSnapshotter)
+	if !ok {
+		return ""
+	}
+
+	vals := vs.SnapshotValues()
+	keys := make([]string, 0, len(vals))
+	for k := range vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=%v", k, vals[k])
+	}
+	return strings.Join(pairs, " ")
+}
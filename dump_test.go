@@ -0,0 +1,42 @@
+package datadog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDump(t *testing.T) {
+	rep := NewReporter(nil)
+	defer rep.Clear()
+
+	FetchGauge(rep, "gauge", "a:b").Update(3)
+	FetchGaugeStats(rep, "stats").Update(2)
+	FetchOperation(rep, "op", time.Millisecond).Record(time.Millisecond)
+	FetchDistribution(rep, "dist").Update(1)
+	rep.Register(&panickingMetric{BaseMetric{name: "custom"}})
+
+	var buf bytes.Buffer
+	if err := rep.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := map[string]string{
+		"gauge [a:b] ": "value=3",
+		"stats [] ":    "avg=2 last=2 max=2 min=2",
+		"op [] ":       "latency.count=1",
+		"dist [] ":     "count=1",
+		"custom [] ":   "",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d lines, got %q", len(want), lines)
+	}
+	for _, line := range lines {
+		for prefix, vals := range want {
+			if strings.HasPrefix(line+" ", prefix) && !strings.Contains(line, vals) {
+				t.Errorf("expected %q in %q", vals, line)
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package datadog
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// NewFromEnv creates a client and an un-started reporter, configured via the
+// environment variables used by the Datadog agent:
+//
+//	DD_API_KEY  the API key (required)
+//	DD_APP_KEY  the application key (optional)
+//	DD_SITE     the Datadog site, e.g. "datadoghq.eu" (optional)
+//	DD_HOST     the host name, defaults to `os.Hostname()`
+//	DD_TAGS     space or comma separated tags for the reporter (optional)
+//
+// Additional options are applied after the environment configuration.
+func NewFromEnv(opts ...Option) (*Client, *MetricReporter, error) {
+	apiKey := os.Getenv("DD_API_KEY")
+	if apiKey == "" {
+		return nil, nil, errors.New("Datadog API key required, DD_API_KEY is not set")
+	}
+
+	host := os.Getenv("DD_HOST")
+	if host == "" {
+		var err error
+		if host, err = os.Hostname(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var envOpts []Option
+	if site := os.Getenv("DD_SITE"); site != "" {
+		envOpts = append(envOpts, WithEndpoint("https://api."+site+"/api/v1"))
+	}
+	if appKey := os.Getenv("DD_APP_KEY"); appKey != "" {
+		envOpts = append(envOpts, WithAppKey(appKey))
+	}
+
+	client := New(host, apiKey, append(envOpts, opts...)...)
+	tags := strings.FieldsFunc(os.Getenv("DD_TAGS"), func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+	return client, client.Reporter(tags...), nil
+}
@@ -0,0 +1,46 @@
+package datadog
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// errorLog logs report errors, throttling identical consecutive errors
+type errorLog struct {
+	lock     sync.Mutex
+	last     string
+	loggedAt time.Time
+	repeated int
+}
+
+// log logs err, unless it repeats the previous error within interval.
+// Repetitions are summarised once the interval has passed, the error
+// changes or reports succeed again, signalled by a nil err.
+func (l *errorLog) log(err error, interval time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if err == nil {
+		if l.repeated != 0 {
+			log.Printf("Datadog series error resolved: %s (repeated %d times)", l.last, l.repeated)
+		}
+		l.last, l.repeated = "", 0
+		return
+	}
+
+	msg, now := err.Error(), clock.Now()
+	if interval > 0 && msg == l.last {
+		if now.Sub(l.loggedAt) < interval {
+			l.repeated++
+			return
+		}
+		log.Printf("Datadog series error: %s (repeated %d times)", msg, l.repeated+1)
+	} else {
+		if l.repeated != 0 {
+			log.Printf("Datadog series error: %s (repeated %d times)", l.last, l.repeated)
+		}
+		log.Printf("Datadog series error: %s", msg)
+	}
+	l.last, l.loggedAt, l.repeated = msg, now, 0
+}
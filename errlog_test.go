@@ -0,0 +1,42 @@
+package datadog
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorLogThrottles(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	fc := NewFakeClock(time.Unix(1000, 0))
+	SetClock(fc)
+	defer SetClock(nil)
+
+	var l errorLog
+	err := errors.New("boom")
+	for i := 0; i < 3; i++ {
+		l.log(err, time.Minute)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 1 {
+		t.Errorf("expected repeated errors to be throttled, got %d lines", n)
+	}
+
+	fc.Advance(time.Minute)
+	l.log(err, time.Minute)
+	if !strings.HasSuffix(buf.String(), "Datadog series error: boom (repeated 3 times)\n") {
+		t.Errorf("expected a summary once the interval passed, got %q", buf.String())
+	}
+
+	l.log(err, time.Minute)
+	l.log(nil, time.Minute)
+	if !strings.HasSuffix(buf.String(), "Datadog series error resolved: boom (repeated 1 times)\n") {
+		t.Errorf("expected a summary once reports succeed, got %q", buf.String())
+	}
+}
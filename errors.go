@@ -0,0 +1,36 @@
+package datadog
+
+import "strings"
+
+// MultiError aggregates multiple errors, e.g. from a report which was
+// submitted in several requests
+type MultiError struct {
+	errs []error
+}
+
+// newMultiError returns nil if errs is empty, the single error if there is
+// only one, or a MultiError otherwise
+func newMultiError(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return &MultiError{errs: errs}
+}
+
+// Error implements the error interface
+func (e *MultiError) Error() string {
+	msgs := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Errors returns the aggregated errors
+func (e *MultiError) Errors() []error { return e.errs }
+
+// Unwrap returns the aggregated errors, for use with errors.Is and errors.As
+func (e *MultiError) Unwrap() []error { return e.errs }
@@ -14,6 +14,12 @@ func NewEWMA(alpha float64) *EWMA {
 	return &EWMA{alpha: alpha}
 }
 
+// NewEWMAWithRate constructs a new, initialized EWMA with the given alpha
+// and a pre-set rate of events per second.
+func NewEWMAWithRate(alpha, rate float64) *EWMA {
+	return &EWMA{alpha: alpha, rate: rate / float64(1e9), init: true}
+}
+
 // NewEWMA1 constructs a new EWMA for a one-minute moving average.
 func NewEWMA1() *EWMA {
 	return NewEWMA(1 - math.Exp(-5.0/60.0/1))
@@ -47,6 +53,14 @@ func (a *EWMA) Rate() float64 {
 	return a.rate * float64(1e9)
 }
 
+// Snapshot returns the moving average rate of events per second and whether
+// the average has been initialized by a first tick.
+func (a *EWMA) Snapshot() (rate float64, initialized bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.rate * float64(1e9), a.init
+}
+
 // Tick ticks the clock to update the moving average.  It assumes it is called
 // every five seconds.
 func (a *EWMA) Tick() {
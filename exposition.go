@@ -0,0 +1,265 @@
+package datadog
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Exposition writes all registered metrics to w in the Prometheus text
+// format, so the same instrumentation can serve a scrape endpoint. Metrics
+// are not flushed, so this has no side effects on reported data. Samples
+// are grouped by metric family, sorted by name.
+//
+// Counters are rendered as counters, gauges as gauges and meters as a
+// `_total` counter plus rate gauges. Histograms and timers are rendered as
+// histograms with `_bucket` series for ExpositionBuckets, `_sum` and
+// `_count`. As reservoir samples hold a subset of values, bucket counts are
+// estimated from the sampled fraction of values below each bound, which may
+// decrease between scrapes. Sketches are rendered as summaries with
+// quantiles, `_sum` and `_count`. Operations are rendered as a meter plus a
+// `_latency` histogram. Distributions in bucketed mode are rendered as
+// histograms, counting all observations since creation. Timers are reported
+// in their configured unit. Other metrics are skipped, as are samples whose
+// family was already rendered with a different type by a metric with a
+// lower name, e.g. a gauge `x_total` after a meter `x`.
+//
+// Series tags are extended like on flush, including DefaultTags, TagVersion
+// and type tags, and converted to labels. If a label is set more than once,
+// the last value wins.
+func (rep *MetricReporter) Exposition(w io.Writer) error {
+	mets := rep.registered()
+	sort.Slice(mets, func(i, j int) bool {
+		if a, b := mets[i].Name(), mets[j].Name(); a != b {
+			return a < b
+		}
+		return NewMetricID(mets[i].Name(), mets[i].Tags()) < NewMetricID(mets[j].Name(), mets[j].Tags())
+	})
+
+	buckets := rep.ExpositionBuckets
+	if len(buckets) == 0 {
+		buckets = DefaultExpositionBuckets
+	}
+	ew := &expositionWriter{
+		tags:     rep.reportTags(),
+		typeTags: rep.registeredTypeTags(),
+		buckets:  buckets,
+		families: make(map[string]*promFamily),
+	}
+	for _, m := range mets {
+		ew.metric(m)
+	}
+	return ew.writeTo(w)
+}
+
+// DefaultExpositionBuckets are the default bucket bounds of histograms and
+// timers in `Exposition`, e.g. 1ms to 10s for millisecond timers.
+var DefaultExpositionBuckets = []float64{1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// promFamily holds the samples of a metric family
+type promFamily struct {
+	typ   string
+	lines []string
+}
+
+type expositionWriter struct {
+	tags     []string
+	typeTags map[reflect.Type][]string
+	buckets  []float64
+	families map[string]*promFamily
+}
+
+func (ew *expositionWriter) metric(m Metric) {
+	name, tags := promName(m.Name()), joinTags(m.Tags(), ew.tags...)
+	if extra := ew.typeTags[reflect.TypeOf(m)]; len(extra) != 0 {
+		tags = joinTags(tags, extra...)
+	}
+
+	switch v := m.(type) {
+	case *Counter:
+		ew.sample(name+"_total", "counter", "", tags, float64(v.Count()))
+	case *MonotonicCounter:
+		ew.sample(name+"_total", "counter", "", tags, float64(v.Count()))
+	case *FlashCounter:
+		ew.sample(name, "gauge", "", tags, float64(v.Count()))
+	case *CounterU:
+		ew.sample(name+"_total", "counter", "", tags, float64(v.Count()))
+	case *DecayingCounter:
+		ew.sample(name, "gauge", "", tags, v.Value())
+	case *Gauge:
+		ew.sample(name, "gauge", "", tags, float64(v.Value()))
+	case *FlashGauge:
+		ew.sample(name, "gauge", "", tags, float64(v.Value()))
+	case *PercentGauge:
+		ew.sample(name, "gauge", "", tags, v.Value())
+	case *GaugeU:
+		ew.sample(name, "gauge", "", tags, float64(v.Value()))
+	case *GaugeF:
+		ew.sample(name, "gauge", "", tags, v.Value())
+	case *GaugeStats:
+		ew.sample(name, "gauge", "", tags, float64(v.Value()))
+	case *Meter:
+		ew.meter(name, tags, v)
+	case *Histogram:
+		ew.histogram(name, tags, v.sample, 1)
+	case *Sketch:
+		ew.summary(name, tags, v.sample, 1)
+	case *Timer:
+		ew.meter(name, tags, v.Meter)
+		ew.histogram(name, tags, v.sample, v.unit)
+	case *Operation:
+		ew.meter(name, tags, v.meter)
+		ew.histogram(promName(v.timer.name), tags, v.timer.sample, v.timer.unit)
+	case *Distribution:
+		ew.distribution(name, tags, v)
+	}
+}
+
+func (ew *expositionWriter) meter(name string, tags []string, m *Meter) {
+	r := m.Rates()
+	ew.sample(name+"_total", "counter", "", tags, float64(m.Count()))
+	ew.sample(name+"_rate", "gauge", "", tags, r.RateMean)
+	ew.sample(name+"_rate1", "gauge", "", tags, r.Rate1)
+	ew.sample(name+"_rate5", "gauge", "", tags, r.Rate5)
+	ew.sample(name+"_rate15", "gauge", "", tags, r.Rate15)
+}
+
+func (ew *expositionWriter) summary(name string, tags []string, s Sample, unit float64) {
+	// build the snapshot manually, as some samples are reset by Snapshot()
+	snap := NewSampleSnapshot(s.Count(), s.Values())
+	qs := []float64{0.5, 0.75, 0.95, 0.99}
+	for i, p := range snap.Percentiles(qs) {
+		ew.sample(name, "summary", "", joinTags(tags, "quantile:"+promFloat(qs[i])), p/unit)
+	}
+	ew.sample(name, "summary", "_sum", tags, snap.Mean()*float64(snap.Count())/unit)
+	ew.sample(name, "summary", "_count", tags, float64(snap.Count()))
+}
+
+func (ew *expositionWriter) histogram(name string, tags []string, s Sample, unit float64) {
+	// build the snapshot manually, as some samples are reset by Snapshot()
+	snap := NewSampleSnapshot(s.Count(), s.Values())
+	values := snap.Values()
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	count := float64(snap.Count())
+	for _, bound := range ew.buckets {
+		n := sort.Search(len(values), func(i int) bool { return float64(values[i])/unit > bound })
+		var estimate float64
+		if len(values) != 0 {
+			estimate = math.Round(float64(n) / float64(len(values)) * count)
+		}
+		ew.sample(name, "histogram", "_bucket", joinTags(tags, "le:"+promFloat(bound)), estimate)
+	}
+	ew.sample(name, "histogram", "_bucket", joinTags(tags, "le:+Inf"), count)
+	ew.sample(name, "histogram", "_sum", tags, snap.Mean()*count/unit)
+	ew.sample(name, "histogram", "_count", tags, count)
+}
+
+func (ew *expositionWriter) distribution(name string, tags []string, d *Distribution) {
+	if len(d.Buckets) == 0 {
+		return
+	}
+
+	d.lock.Lock()
+	totals, sum := append([]int64(nil), d.totals...), d.sum
+	d.lock.Unlock()
+
+	var total int64
+	for i := 0; i <= len(d.Buckets); i++ {
+		if i < len(totals) {
+			total += totals[i]
+		}
+		bound := "+Inf"
+		if i < len(d.Buckets) {
+			bound = promFloat(d.Buckets[i])
+		}
+		ew.sample(name, "histogram", "_bucket", joinTags(tags, "le:"+bound), float64(total))
+	}
+	ew.sample(name, "histogram", "_sum", tags, sum)
+	ew.sample(name, "histogram", "_count", tags, float64(total))
+}
+
+// sample adds a sample named family plus suffix, e.g. "_count", to the
+// family, unless the family has a different type
+func (ew *expositionWriter) sample(family, typ, suffix string, tags []string, v float64) {
+	f, ok := ew.families[family]
+	if !ok {
+		f = &promFamily{typ: typ}
+		ew.families[family] = f
+	} else if f.typ != typ {
+		return
+	}
+	f.lines = append(f.lines, family+suffix+promLabels(tags)+" "+promFloat(v)+"\n")
+}
+
+// writeTo writes all families, sorted by name, each preceded by a TYPE line
+func (ew *expositionWriter) writeTo(w io.Writer) error {
+	names := make([]string, 0, len(ew.families))
+	for name := range ew.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := ew.families[name]
+		if _, err := fmt.Fprintf(w, "# TYPE %s %s\n", name, f.typ); err != nil {
+			return err
+		}
+		for _, line := range f.lines {
+			if _, err := io.WriteString(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// promLabels converts "key:value" tags to Prometheus labels. Tags without
+// a value are rendered with an empty value. Labels are unique, the last
+// value of a repeated key wins, in the position of its first occurrence.
+func promLabels(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(tags))
+	vals := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		key, val := tag, ""
+		if i := strings.IndexByte(tag, ':'); i > -1 {
+			key, val = tag[:i], tag[i+1:]
+		}
+		key = promName(key)
+		if _, ok := vals[key]; !ok {
+			keys = append(keys, key)
+		}
+		vals[key] = val
+	}
+
+	labels := make([]string, len(keys))
+	for i, key := range keys {
+		labels[i] = key + `="` + promEscaper.Replace(vals[key]) + `"`
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// promEscaper escapes label values as required by the text format
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promName replaces characters which are invalid in Prometheus names
+func promName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func promFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,90 @@
+package datadog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpositionGroupsFamilies(t *testing.T) {
+	rep := NewReporter(nil)
+	defer rep.Clear()
+
+	FetchMeter(rep, "jobs", "queue:a").Mark(1)
+	FetchMeter(rep, "jobs", "queue:b").Mark(2)
+	FetchGauge(rep, "jobs_total", "queue:c").Update(1)
+	FetchTimer(rep, "latency", time.Millisecond, "queue:a").Update(time.Millisecond)
+	FetchTimer(rep, "latency", time.Millisecond, "queue:b").Update(time.Millisecond)
+	FetchOperation(rep, "op", time.Millisecond).Record(time.Millisecond)
+	FetchSketch(rep, "sizes", 0.01).Update(10)
+
+	var buf bytes.Buffer
+	if err := rep.Exposition(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	family := ""
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			family = strings.Fields(line)[2]
+			if seen[family] {
+				t.Errorf("family %s is split", family)
+			}
+			seen[family] = true
+			continue
+		}
+		if !strings.HasPrefix(line, family) {
+			t.Errorf("sample %q outside of its family %s", line, family)
+		}
+	}
+	for _, family := range []string{"jobs_total", "latency", "op_total", "op_latency", "sizes"} {
+		if !seen[family] {
+			t.Errorf("expected family %s", family)
+		}
+	}
+	if n := strings.Count(buf.String(), "jobs_total{"); n != 2 {
+		t.Errorf("expected conflicting gauge to be skipped, got %d jobs_total samples", n)
+	}
+}
+
+func TestExpositionHistograms(t *testing.T) {
+	rep := NewReporter(New("host", "key"), "env:prod")
+	defer rep.Clear()
+	rep.TagVersion = true
+	rep.DefaultTags = func() []string { return []string{"env:test", "note:a \"b\"\n\\"} }
+
+	tm := FetchTimer(rep, "latency", time.Millisecond)
+	tm.Update(2 * time.Millisecond)
+	tm.Update(20 * time.Millisecond)
+
+	d := FetchDistribution(rep, "sizes")
+	d.Buckets = []float64{10}
+	d.Update(5)
+	d.Flush(0)
+	d.Update(50)
+
+	var buf bytes.Buffer
+	if err := rep.Exposition(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	labels := `{env="test",go_datadog_version="` + Version + `",note="a \"b\"\n\\"`
+	for _, line := range []string{
+		"# TYPE latency histogram\n",
+		`latency_bucket` + labels + `,le="1"} 0` + "\n",
+		`latency_bucket` + labels + `,le="2.5"} 1` + "\n",
+		`latency_bucket` + labels + `,le="+Inf"} 2` + "\n",
+		`latency_sum` + labels + `} 22` + "\n",
+		`latency_count` + labels + `} 2` + "\n",
+		`sizes_bucket` + labels + `,le="10"} 1` + "\n",
+		`sizes_bucket` + labels + `,le="+Inf"} 2` + "\n",
+		`sizes_sum` + labels + `} 55` + "\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %q in exposition:\n%s", line, out)
+		}
+	}
+}
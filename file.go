@@ -0,0 +1,137 @@
+package datadog
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// FileClient appends series to a local file instead of submitting them to
+// the Datadog API, e.g. for environments without egress. Each report is
+// written as a single line of JSON, in the same format as the API payload,
+// so files can be replayed by POSTing each line to the series endpoint.
+type FileClient struct {
+	Host string
+	Path string
+
+	// MaxSize rotates the file once a write would exceed the given number
+	// of bytes. Rotated files are suffixed with `.1`, `.2`, etc., newest
+	// first. Zero disables rotation.
+	MaxSize int64
+
+	// MaxBackups is the number of rotated files to keep, defaults to 1
+	MaxBackups int
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileClient creates a new file client
+func NewFileClient(host, path string) *FileClient {
+	return &FileClient{Host: host, Path: path}
+}
+
+// PostSeries appends series data to the file
+func (c *FileClient) PostSeries(series []*Series) error {
+	return c.PostSeriesContext(context.Background(), series)
+}
+
+// PostSeriesContext is like PostSeries, but returns early when ctx is
+// already cancelled.
+func (c *FileClient) PostSeriesContext(ctx context.Context, series []*Series) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(seriesMessage{series})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.file == nil {
+		if err := c.open(); err != nil {
+			return err
+		}
+	}
+	if c.MaxSize > 0 && c.size > 0 && c.size+int64(len(line)) > c.MaxSize {
+		if err := c.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := c.file.Write(line)
+	c.size += int64(n)
+	return err
+}
+
+// Close closes the underlying file. It is reopened by the next write.
+func (c *FileClient) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+// Reporter creates a `MetricReporter` which writes to the file. The returned
+// reporter will not be started.
+func (c *FileClient) Reporter(tags ...string) *MetricReporter {
+	return newReporter(c, tags...)
+}
+
+// Private host accessor, used by reporters
+func (c *FileClient) hostname() string { return c.Host }
+
+// open opens the file for appending. The lock must be held.
+func (c *FileClient) open() error {
+	f, err := os.OpenFile(c.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	c.file, c.size = f, info.Size()
+	return nil
+}
+
+// rotate shifts the current file and existing backups, dropping the oldest,
+// and reopens the file. The lock must be held.
+func (c *FileClient) rotate() error {
+	if err := c.file.Close(); err != nil {
+		return err
+	}
+	c.file = nil
+
+	n := c.MaxBackups
+	if n < 1 {
+		n = 1
+	}
+	backup := func(i int) string { return c.Path + "." + strconv.Itoa(i) }
+
+	if err := os.Remove(backup(n)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := n - 1; i > 0; i-- {
+		if err := os.Rename(backup(i), backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(c.Path, backup(1)); err != nil {
+		return err
+	}
+	return c.open()
+}
@@ -0,0 +1,47 @@
+package datadog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileClientRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "series.ndjson")
+	c := NewFileClient("host", path)
+	c.MaxSize = 100
+	c.MaxBackups = 2
+	defer c.Close()
+
+	// each report exceeds half of MaxSize, so every write rotates
+	for i := 0; i < 5; i++ {
+		if err := c.PostSeries([]*Series{NewSeries("file.client.series", int64(i), 1, nil, MT_GAUGE)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, name := range []string{path, path + ".1", path + ".2"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+		if len(lines) != 1 {
+			t.Fatalf("%s: expected 1 report, got %d", name, len(lines))
+		}
+
+		var msg struct{ Series []*Series }
+		if err := json.Unmarshal(lines[0], &msg); err != nil {
+			t.Fatalf("%s: expected a series payload, got %v", name, err)
+		}
+		if ts := msg.Series[0].Points[0][0]; ts != float64(4-i) {
+			t.Errorf("%s: expected the report at %d, got %v", name, 4-i, ts)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 backups, got %v", err)
+	}
+}
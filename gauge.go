@@ -1,6 +1,7 @@
 package datadog
 
 import (
+	"math"
 	"sync"
 	"sync/atomic"
 )
@@ -10,6 +11,18 @@ import (
 type Gauge struct {
 	BaseMetric
 	value int64
+
+	// Transform is an optional function applied to the value on flush,
+	// e.g. to convert bytes to megabytes
+	Transform func(float64) float64
+
+	// OnlyChanged omits the gauge on flush unless its value changed since
+	// the previous flush, e.g. for rarely changing values
+	OnlyChanged bool
+
+	flushLock    sync.Mutex
+	flushed      bool
+	flushedValue int64
 }
 
 // NewGauge creates a new gauge
@@ -17,6 +30,11 @@ func NewGauge(name string, tags ...string) *Gauge {
 	return &Gauge{BaseMetric: BaseMetric{name: name, tags: tags}}
 }
 
+// NewGaugeM is like NewGauge, but with tags converted by TagsFromMap
+func NewGaugeM(name string, tags map[string]string) *Gauge {
+	return NewGauge(name, TagsFromMap(tags)...)
+}
+
 // FetchGauge returns or registers a new one
 func FetchGauge(rep *MetricReporter, name string, tags ...string) *Gauge {
 	return rep.Fetch(func() Metric { return NewGauge(name, tags...) }, name, tags...).(*Gauge)
@@ -29,28 +47,119 @@ func RegisterGauge(rep *MetricReporter, name string, tags ...string) *Gauge {
 	return m
 }
 
+func (m *Gauge) withTags(name string, tags []string) Metric {
+	g := NewGauge(name, tags...)
+	g.Transform, g.OnlyChanged = m.Transform, m.OnlyChanged
+	return g
+}
+
 // Update updates the gauge's value.
 func (g *Gauge) Update(v int64) {
 	atomic.StoreInt64(&g.value, v)
 }
 
+// Inc increments the gauge's value by the given amount.
+func (g *Gauge) Inc(i int64) {
+	atomic.AddInt64(&g.value, i)
+}
+
+// Dec decrements the gauge's value by the given amount.
+func (g *Gauge) Dec(i int64) {
+	atomic.AddInt64(&g.value, -i)
+}
+
 // Value returns the gauge's current value.
 func (g *Gauge) Value() int64 {
 	return atomic.LoadInt64(&g.value)
 }
 
+// SwapValue atomically sets the gauge's value to v and returns the previous value.
+func (g *Gauge) SwapValue(v int64) int64 {
+	return atomic.SwapInt64(&g.value, v)
+}
+
 // Flush returns series
 func (m *Gauge) Flush(now int64) []*Series {
+	return m.flush(now, m.Value())
+}
+
+func (m *Gauge) flush(now, value int64) []*Series {
+	if m.OnlyChanged && !m.changed(value) {
+		return nil
+	}
+
+	var v interface{} = value
+	if m.Transform != nil {
+		v = m.Transform(float64(value))
+	}
 	return []*Series{
-		NewSeries(m.name+".value", now, m.Value(), m.tags, MT_GAUGE),
+		NewSeries(m.name+".value", now, v, m.tags, MT_GAUGE),
+	}
+}
+
+// changed records value as flushed and reports whether it differs from
+// the previously flushed value
+func (m *Gauge) changed(value int64) bool {
+	m.flushLock.Lock()
+	defer m.flushLock.Unlock()
+
+	if m.flushed && value == m.flushedValue {
+		return false
 	}
+	m.flushed, m.flushedValue = true, value
+	return true
+}
+
+// FlashGauge is a gauge that resets to 0 after each flush, e.g. for readings
+// of external delta counters
+type FlashGauge struct {
+	Gauge
+}
+
+// NewFlashGauge creates a new reset gauge
+func NewFlashGauge(name string, tags ...string) *FlashGauge {
+	return &FlashGauge{Gauge{BaseMetric: BaseMetric{name: name, tags: tags}}}
+}
+
+// FetchFlashGauge returns or registers a new one
+func FetchFlashGauge(rep *MetricReporter, name string, tags ...string) *FlashGauge {
+	return rep.Fetch(func() Metric { return NewFlashGauge(name, tags...) }, name, tags...).(*FlashGauge)
+}
+
+// RegisterFlashGauge registers a reset gauge
+func RegisterFlashGauge(rep *MetricReporter, name string, tags ...string) *FlashGauge {
+	m := NewFlashGauge(name, tags...)
+	rep.Register(m)
+	return m
+}
+
+func (m *FlashGauge) withTags(name string, tags []string) Metric {
+	g := NewFlashGauge(name, tags...)
+	g.Transform, g.OnlyChanged = m.Transform, m.OnlyChanged
+	return g
+}
+
+// Flush returns series and resets the gauge
+func (m *FlashGauge) Flush(now int64) []*Series {
+	return m.flush(now, m.SwapValue(0))
 }
 
-// GaugeF is like a normal Gauge, but holds floating point values
+// GaugeF is like a normal Gauge, but holds floating point values. The value
+// is stored as IEEE 754 bits to allow lock-free updates.
 type GaugeF struct {
 	BaseMetric
-	value float64
-	lock  sync.Mutex
+	bits uint64
+
+	// Transform is an optional function applied to the value on flush
+	Transform func(float64) float64
+
+	// OnlyChanged omits the gauge on flush unless its value changed since
+	// the previous flush, e.g. for rarely changing values
+	OnlyChanged bool
+
+	flushLock   sync.Mutex
+	flushed     bool
+	flushedBits uint64
 }
 
 // NewGaugeF creates a new gauge
@@ -70,24 +179,272 @@ func RegisterGaugeF(rep *MetricReporter, name string, tags ...string) *GaugeF {
 	return m
 }
 
+func (m *GaugeF) withTags(name string, tags []string) Metric {
+	g := NewGaugeF(name, tags...)
+	g.Transform, g.OnlyChanged = m.Transform, m.OnlyChanged
+	return g
+}
+
 // Update updates the gauge's value.
 func (g *GaugeF) Update(v float64) {
-	g.lock.Lock()
-	g.value = v
-	g.lock.Unlock()
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
 }
 
 // Value returns the gauge's current value.
 func (g *GaugeF) Value() float64 {
-	g.lock.Lock()
-	v := g.value
-	g.lock.Unlock()
-	return v
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
 }
 
 // Flush returns series
 func (m *GaugeF) Flush(now int64) []*Series {
+	bits := atomic.LoadUint64(&m.bits)
+	if m.OnlyChanged && !m.changed(bits) {
+		return nil
+	}
+
+	v := math.Float64frombits(bits)
+	if m.Transform != nil {
+		v = m.Transform(v)
+	}
+	return []*Series{
+		NewSeries(m.name+".value", now, v, m.tags, MT_GAUGE),
+	}
+}
+
+// changed records bits as flushed and reports whether they differ from the
+// previously flushed bits
+func (m *GaugeF) changed(bits uint64) bool {
+	m.flushLock.Lock()
+	defer m.flushLock.Unlock()
+
+	if m.flushed && bits == m.flushedBits {
+		return false
+	}
+	m.flushed, m.flushedBits = true, bits
+	return true
+}
+
+// PercentGauge is a floating point gauge which clamps values to the range
+// of Min to Max, 0 to 100 by default, so bad inputs cannot disrupt
+// dashboards. Out-of-range updates are counted, see Clamped.
+type PercentGauge struct {
+	GaugeF
+	Min, Max float64
+
+	// ReportClamped emits an additional `.clamped` counter with the number
+	// of out-of-range updates
+	ReportClamped bool
+
+	clamped int64
+}
+
+// NewPercentGauge creates a new gauge with a range of 0 to 100
+func NewPercentGauge(name string, tags ...string) *PercentGauge {
+	return &PercentGauge{GaugeF: GaugeF{BaseMetric: BaseMetric{name: name, tags: tags}}, Max: 100}
+}
+
+// FetchPercentGauge returns or registers a new one
+func FetchPercentGauge(rep *MetricReporter, name string, tags ...string) *PercentGauge {
+	return rep.Fetch(func() Metric { return NewPercentGauge(name, tags...) }, name, tags...).(*PercentGauge)
+}
+
+// RegisterPercentGauge registers a percent gauge
+func RegisterPercentGauge(rep *MetricReporter, name string, tags ...string) *PercentGauge {
+	m := NewPercentGauge(name, tags...)
+	rep.Register(m)
+	return m
+}
+
+func (m *PercentGauge) withTags(name string, tags []string) Metric {
+	g := NewPercentGauge(name, tags...)
+	g.Transform, g.OnlyChanged = m.Transform, m.OnlyChanged
+	g.Min, g.Max, g.ReportClamped = m.Min, m.Max, m.ReportClamped
+	return g
+}
+
+// Update updates the gauge's value, clamped to the range. NaN values
+// are ignored and counted as out-of-range.
+func (g *PercentGauge) Update(v float64) {
+	switch {
+	case math.IsNaN(v):
+		atomic.AddInt64(&g.clamped, 1)
+		return
+	case v < g.Min:
+		atomic.AddInt64(&g.clamped, 1)
+		v = g.Min
+	case v > g.Max:
+		atomic.AddInt64(&g.clamped, 1)
+		v = g.Max
+	}
+	g.GaugeF.Update(v)
+}
+
+// UpdateRatio updates the gauge with a ratio of 0 to 1, as a percentage.
+func (g *PercentGauge) UpdateRatio(r float64) { g.Update(r * 100) }
+
+// Clamped returns the number of out-of-range updates.
+func (g *PercentGauge) Clamped() int64 {
+	return atomic.LoadInt64(&g.clamped)
+}
+
+// Flush returns series
+func (m *PercentGauge) Flush(now int64) []*Series {
+	series := m.GaugeF.Flush(now)
+	if m.ReportClamped {
+		series = append(series, NewSeries(m.name+".clamped", now, m.Clamped(), m.tags, MT_COUNTER))
+	}
+	return series
+}
+
+// GaugeU is like a normal Gauge, but holds unsigned values, e.g. byte
+// totals from /proc which may exceed the range of an int64.
+type GaugeU struct {
+	BaseMetric
+	value uint64
+}
+
+// NewGaugeU creates a new gauge
+func NewGaugeU(name string, tags ...string) *GaugeU {
+	return &GaugeU{BaseMetric: BaseMetric{name: name, tags: tags}}
+}
+
+// FetchGaugeU returns or registers a new one
+func FetchGaugeU(rep *MetricReporter, name string, tags ...string) *GaugeU {
+	return rep.Fetch(func() Metric { return NewGaugeU(name, tags...) }, name, tags...).(*GaugeU)
+}
+
+// RegisterGaugeU registers a gauge
+func RegisterGaugeU(rep *MetricReporter, name string, tags ...string) *GaugeU {
+	m := NewGaugeU(name, tags...)
+	rep.Register(m)
+	return m
+}
+
+func (m *GaugeU) withTags(name string, tags []string) Metric { return NewGaugeU(name, tags...) }
+
+// Update updates the gauge's value.
+func (g *GaugeU) Update(v uint64) {
+	atomic.StoreUint64(&g.value, v)
+}
+
+// Value returns the gauge's current value.
+func (g *GaugeU) Value() uint64 {
+	return atomic.LoadUint64(&g.value)
+}
+
+// Flush returns series
+func (m *GaugeU) Flush(now int64) []*Series {
 	return []*Series{
 		NewSeries(m.name+".value", now, m.Value(), m.tags, MT_GAUGE),
 	}
 }
+
+// GaugeStats is a gauge which also tracks the minimum, maximum and average
+// of all values observed between flushes, so transient spikes are visible
+// even with long flush intervals.
+type GaugeStats struct {
+	BaseMetric
+	lock sync.Mutex
+
+	last, min, max, sum int64
+	count               int64
+}
+
+// NewGaugeStats creates a new gauge with stats
+func NewGaugeStats(name string, tags ...string) *GaugeStats {
+	return &GaugeStats{BaseMetric: BaseMetric{name: name, tags: tags}}
+}
+
+// FetchGaugeStats returns or registers a new one
+func FetchGaugeStats(rep *MetricReporter, name string, tags ...string) *GaugeStats {
+	return rep.Fetch(func() Metric { return NewGaugeStats(name, tags...) }, name, tags...).(*GaugeStats)
+}
+
+// RegisterGaugeStats registers a gauge with stats
+func RegisterGaugeStats(rep *MetricReporter, name string, tags ...string) *GaugeStats {
+	m := NewGaugeStats(name, tags...)
+	rep.Register(m)
+	return m
+}
+
+func (m *GaugeStats) withTags(name string, tags []string) Metric { return NewGaugeStats(name, tags...) }
+
+// Update updates the gauge's value.
+func (g *GaugeStats) Update(v int64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.count == 0 || v < g.min {
+		g.min = v
+	}
+	if g.count == 0 || v > g.max {
+		g.max = v
+	}
+	g.last = v
+	g.sum += v
+	g.count++
+}
+
+// Value returns the gauge's current value.
+func (g *GaugeStats) Value() int64 {
+	g.lock.Lock()
+	v := g.last
+	g.lock.Unlock()
+	return v
+}
+
+// Flush returns series and resets the interval stats. If no values were
+// observed since the last flush, all stats equal the current value.
+func (m *GaugeStats) Flush(now int64) []*Series {
+	m.lock.Lock()
+	last, min, max, avg := m.last, m.min, m.max, float64(m.last)
+	if m.count == 0 {
+		min, max = last, last
+	} else {
+		avg = float64(m.sum) / float64(m.count)
+	}
+	m.sum, m.count = 0, 0
+	m.lock.Unlock()
+
+	return NewSeriesBuilder(m.name, now, m.tags).
+		Gauge(".min", min).
+		Gauge(".max", max).
+		Gauge(".avg", avg).
+		Gauge(".last", last).
+		Series()
+}
+
+// SnapshotValues returns the current value, without flushing.
+func (g *Gauge) SnapshotValues() map[string]interface{} {
+	var v interface{} = g.Value()
+	if g.Transform != nil {
+		v = g.Transform(float64(g.Value()))
+	}
+	return map[string]interface{}{"value": v}
+}
+
+// SnapshotValues returns the current value, without flushing.
+func (g *GaugeF) SnapshotValues() map[string]interface{} {
+	v := g.Value()
+	if g.Transform != nil {
+		v = g.Transform(v)
+	}
+	return map[string]interface{}{"value": v}
+}
+
+// SnapshotValues returns the current value, without flushing.
+func (g *GaugeU) SnapshotValues() map[string]interface{} {
+	return map[string]interface{}{"value": g.Value()}
+}
+
+// SnapshotValues returns the current interval stats, without resetting them.
+func (g *GaugeStats) SnapshotValues() map[string]interface{} {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	min, max, avg := g.last, g.last, float64(g.last)
+	if g.count != 0 {
+		min, max, avg = g.min, g.max, float64(g.sum)/float64(g.count)
+	}
+	return map[string]interface{}{"min": min, "max": max, "avg": avg, "last": g.last}
+}
@@ -0,0 +1,93 @@
+package datadog
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// mutexGaugeF is the previous, mutex based implementation of GaugeF
+type mutexGaugeF struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (g *mutexGaugeF) Update(v float64) {
+	g.mu.Lock()
+	g.value = v
+	g.mu.Unlock()
+}
+
+func (g *mutexGaugeF) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+func TestGaugeFConcurrent(t *testing.T) {
+	g := NewGaugeF("gauge")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(v float64) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				g.Update(v)
+				g.Value()
+			}
+		}(float64(i))
+	}
+	wg.Wait()
+
+	if v := g.Value(); v < 0 || v > 7 {
+		t.Errorf("unexpected value %v", v)
+	}
+}
+
+func TestGaugeOnlyChangedConcurrentFlush(t *testing.T) {
+	g := NewGauge("gauge")
+	g.OnlyChanged = true
+	g.Update(1)
+	gf := NewGaugeF("gaugef")
+	gf.OnlyChanged = true
+	gf.Update(1)
+
+	var wg sync.WaitGroup
+	var n int64
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			atomic.AddInt64(&n, int64(len(g.Flush(1))+len(gf.Flush(1))))
+		}()
+	}
+	wg.Wait()
+
+	if n != 2 {
+		t.Errorf("expected each unchanged gauge to flush once, got %d series", n)
+	}
+}
+
+// Run with -race to compare under the race detector, e.g.
+//
+//	go test -race -run=NONE -bench=GaugeF
+func BenchmarkGaugeF(b *testing.B) {
+	g := NewGaugeF("gauge")
+	b.RunParallel(func(pb *testing.PB) {
+		for v := 0.0; pb.Next(); v++ {
+			g.Update(v)
+			g.Value()
+		}
+	})
+}
+
+func BenchmarkGaugeFMutex(b *testing.B) {
+	g := new(mutexGaugeF)
+	b.RunParallel(func(pb *testing.PB) {
+		for v := 0.0; pb.Next(); v++ {
+			g.Update(v)
+			g.Value()
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package datadog
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+var errNotHijacker = errors.New("Datadog instrumented handler: ResponseWriter does not support hijacking")
+
+// InstrumentHandler wraps next and records metrics for each request:
+//
+//   - `<name>.requests`, a meter of requests
+//   - `<name>.latency`, a timer of request durations in milliseconds
+//   - `<name>.responses`, counters tagged with the `status_class` of the
+//     response, e.g. `status_class:5xx`
+//
+// Metrics are registered with rep via Fetch.
+func InstrumentHandler(rep *MetricReporter, name string, next http.Handler) http.Handler {
+	requests := FetchMeter(rep, name+".requests")
+	latency := FetchTimer(rep, name+".latency", time.Millisecond)
+
+	var statuses [numStatusClasses]*Counter
+	for i, tag := range statusClassTags {
+		// informational responses are never final, so skip the error class
+		if i != statusError {
+			statuses[i] = FetchCounter(rep, name+".responses", tag)
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := clock.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			latency.UpdateSince(start)
+			requests.Mark(1)
+			if c := statuses[statusClass(sw.status())]; c != nil {
+				c.Inc(1)
+			}
+		}()
+
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// statusWriter records the status code of a response
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 && code >= 200 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher, if the underlying writer supports it
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.code == 0 {
+			w.code = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, if the underlying writer supports it
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errNotHijacker
+}
+
+// Unwrap returns the underlying writer, for use with http.ResponseController
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
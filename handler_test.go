@@ -0,0 +1,51 @@
+package datadog
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (r *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.hijacked = true
+	return nil, nil, nil
+}
+
+func TestInstrumentHandlerFlushHijack(t *testing.T) {
+	rep := NewReporter(New("host", "key"))
+	defer rep.Clear()
+
+	h := InstrumentHandler(rep, "web", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hijack" {
+			if _, _, err := w.(http.Hijacker).Hijack(); err != nil {
+				t.Errorf("failed to hijack: %s", err)
+			}
+			return
+		}
+		w.(http.Flusher).Flush()
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !rec.Flushed {
+		t.Error("expected flush to be forwarded")
+	}
+
+	hr := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	h.ServeHTTP(hr, httptest.NewRequest("GET", "/hijack", nil))
+	if !hr.hijacked {
+		t.Error("expected hijack to be forwarded")
+	}
+
+	sw := &statusWriter{ResponseWriter: httptest.NewRecorder()}
+	if _, _, err := sw.Hijack(); err == nil {
+		t.Error("expected error for writer without hijack support")
+	}
+}
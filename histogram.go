@@ -1,9 +1,63 @@
 package datadog
 
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultPercentiles are reported by histograms and timers
+var defaultPercentiles = []float64{0.5, 0.75, 0.95, 0.99}
+
+// DefaultPercentileName names percentile series `.median` and
+// `.percentile.<p>`, e.g. `.percentile.95`
+func DefaultPercentileName(p float64) string {
+	if p == 0.5 {
+		return ".median"
+	}
+	return ".percentile." + formatPercentile(p)
+}
+
+// AgentPercentileName names percentile series like the Datadog agent,
+// `.median` and `.<p>percentile`, e.g. `.95percentile`
+func AgentPercentileName(p float64) string {
+	if p == 0.5 {
+		return ".median"
+	}
+	return "." + formatPercentile(p) + "percentile"
+}
+
+func formatPercentile(p float64) string {
+	return strconv.FormatFloat(p*100, 'f', -1, 64)
+}
+
 // A standard histogram
 type Histogram struct {
 	BaseMetric
+	activity
 	sample Sample
+
+	// Transform is an optional function applied to all values but the count
+	// on flush, e.g. to convert units. It should be a pure scaling for the
+	// standard deviation to remain meaningful.
+	Transform func(float64) float64
+
+	// ReportSampleSize emits an additional `.samplesize` gauge with the
+	// number of values in the sample, to detect saturated reservoirs
+	ReportSampleSize bool
+
+	// PercentileNamer returns the series suffix for a percentile,
+	// defaults to `DefaultPercentileName`
+	PercentileNamer func(p float64) string
+
+	// ReportDistribution additionally flushes the values recorded since the
+	// previous flush as a distribution, see FlushDistribution. It must be
+	// set before the first update.
+	ReportDistribution bool
+
+	distLock sync.Mutex
+	dist     *UniformSample
 }
 
 // NewCustomHistogram creates a new custom histogram
@@ -25,7 +79,12 @@ func RegisterCustomHistogram(rep *MetricReporter, name string, sample Sample, ta
 
 // NewHistogram creates a new histogram with default sampling
 func NewHistogram(name string, tags ...string) *Histogram {
-	return NewCustomHistogram(name, NewDefaultSample(), tags...)
+	return NewCustomHistogram(name, defaultSampleFactory(), tags...)
+}
+
+// NewHistogramM is like NewHistogram, but with tags converted by TagsFromMap
+func NewHistogramM(name string, tags map[string]string) *Histogram {
+	return NewHistogram(name, TagsFromMap(tags)...)
 }
 
 // FetchHistogram returns or registers a new one
@@ -35,31 +94,177 @@ func FetchHistogram(rep *MetricReporter, name string, tags ...string) *Histogram
 
 // RegisterHistogram registers a histogram
 func RegisterHistogram(rep *MetricReporter, name string, tags ...string) *Histogram {
-	return RegisterCustomHistogram(rep, name, NewDefaultSample(), tags...)
+	return RegisterCustomHistogram(rep, name, defaultSampleFactory(), tags...)
+}
+
+// NewFlashHistogram creates a new histogram with a flash sample. The sample is
+// cleared on every flush, so reported stats reflect only the last interval.
+func NewFlashHistogram(name string, tags ...string) *Histogram {
+	return NewCustomHistogram(name, NewFlashSample(defaultReservoirSize), tags...)
+}
+
+// FetchFlashHistogram returns or registers a new one
+func FetchFlashHistogram(rep *MetricReporter, name string, tags ...string) *Histogram {
+	return rep.Fetch(func() Metric { return NewFlashHistogram(name, tags...) }, name, tags...).(*Histogram)
+}
+
+// RegisterFlashHistogram registers a flash histogram
+func RegisterFlashHistogram(rep *MetricReporter, name string, tags ...string) *Histogram {
+	return RegisterCustomHistogram(rep, name, NewFlashSample(defaultReservoirSize), tags...)
+}
+
+func (h *Histogram) withTags(name string, tags []string) Metric {
+	hh := NewCustomHistogram(name, newSampleLike(h.sample), tags...)
+	hh.Transform, hh.ReportSampleSize, hh.PercentileNamer, hh.ReportDistribution = h.Transform, h.ReportSampleSize, h.PercentileNamer, h.ReportDistribution
+	return hh
 }
 
 // Clear clears the histogram and its sample.
-func (h *Histogram) Clear() { h.sample.Clear() }
+func (h *Histogram) Clear() {
+	h.sample.Clear()
+	h.distLock.Lock()
+	h.dist = nil
+	h.distLock.Unlock()
+}
 
 // Snapshot returns a read-only snapshot for statistical analysis
 func (h *Histogram) Snapshot() *SampleSnapshot { return h.sample.Snapshot() }
 
+// PercentilesSince returns percentiles of the values observed within the
+// trailing duration d, if the sample implements `TimeWindowSample`. Other
+// samples cannot tell the age of values, so percentiles are computed from
+// all values in the sample. Percentiles are not transformed.
+func (h *Histogram) PercentilesSince(d time.Duration, ps []float64) []float64 {
+	if ws, ok := h.sample.(TimeWindowSample); ok {
+		return ws.SnapshotSince(clock.Now().Add(-d)).Percentiles(ps)
+	}
+	return NewSampleSnapshot(h.sample.Count(), h.sample.Values()).Percentiles(ps)
+}
+
 // Update samples a new value.
-func (h *Histogram) Update(v int64) { h.sample.Update(v) }
+func (h *Histogram) Update(v int64) {
+	h.sample.Update(v)
+	h.record(v)
+	h.touch()
+}
+
+// record adds v to the values of the current interval, if reported as a
+// distribution
+func (h *Histogram) record(v int64) {
+	if !h.ReportDistribution {
+		return
+	}
+
+	h.distLock.Lock()
+	if h.dist == nil {
+		size := defaultReservoirSize
+		if r, ok := h.sample.(interface{ ReservoirSize() int }); ok {
+			size = r.ReservoirSize()
+		}
+		h.dist = NewUniformSample(size)
+	}
+	h.dist.Update(v)
+	h.distLock.Unlock()
+}
+
+// UpdateWeighted samples a new value with the given weight, e.g. for a batch
+// operation representing many units. Requires a `WeightedSample`, other
+// samples record the value once, ignoring the weight.
+func (h *Histogram) UpdateWeighted(v, weight int64) {
+	if ws, ok := h.sample.(*WeightedSample); ok {
+		ws.UpdateWeighted(v, weight)
+		h.record(v)
+		h.touch()
+		return
+	}
+	h.Update(v)
+}
 
 // Flush returns series
 func (h *Histogram) Flush(now int64) []*Series {
+	h.untouch()
 	snap := h.Snapshot()
-	p := snap.Percentiles([]float64{0.5, 0.75, 0.95, 0.99})
-	return []*Series{
-		NewSeries(h.name+".count", now, snap.Count(), h.tags, MT_COUNTER),
-		NewSeries(h.name+".min", now, snap.Min(), h.tags, MT_GAUGE),
-		NewSeries(h.name+".max", now, snap.Max(), h.tags, MT_GAUGE),
-		NewSeries(h.name+".mean", now, snap.Mean(), h.tags, MT_GAUGE),
-		NewSeries(h.name+".stddev", now, snap.StdDev(), h.tags, MT_GAUGE),
-		NewSeries(h.name+".median", now, p[0], h.tags, MT_GAUGE),
-		NewSeries(h.name+".percentile.75", now, p[1], h.tags, MT_GAUGE),
-		NewSeries(h.name+".percentile.95", now, p[2], h.tags, MT_GAUGE),
-		NewSeries(h.name+".percentile.99", now, p[3], h.tags, MT_GAUGE),
+	fn := h.transform()
+	b := NewSeriesBuilder(h.name, now, h.tags)
+	if h.ReportSampleSize {
+		b.Gauge(".samplesize", snap.Size())
+	}
+	series := b.
+		Counter(".count", snap.Count()).
+		Gauge(".min", fn(float64(snap.Min()))).
+		Gauge(".max", fn(float64(snap.Max()))).
+		Gauge(".mean", fn(snap.Mean())).
+		Gauge(".stddev", fn(snap.StdDev())).
+		percentiles(snap, h.PercentileNamer, fn).
+		Series()
+
+	if h.ReportDistribution {
+		if s := h.distribution(now, fn); s != nil {
+			series = append(series, s)
+		}
+	}
+	return series
+}
+
+// FlushDistribution returns the values recorded since the previous flush as
+// a single MT_DISTRIBUTION series named like the histogram, so Datadog can
+// aggregate them across hosts. Values are only recorded when
+// ReportDistribution is set, and are sampled uniformly up to the reservoir
+// size of the histogram's sample. Returns nil if there are no new values.
+func (h *Histogram) FlushDistribution(now int64) []*Series {
+	h.untouch()
+	if s := h.distribution(now, h.transform()); s != nil {
+		return []*Series{s}
+	}
+	return nil
+}
+
+// distribution returns the values of the current interval and starts a new one
+func (h *Histogram) distribution(now int64, fn func(float64) float64) *Series {
+	h.distLock.Lock()
+	dist := h.dist
+	h.dist = nil
+	h.distLock.Unlock()
+
+	if dist == nil || dist.Size() == 0 {
+		return nil
+	}
+
+	vs := dist.Values()
+	values := make([]float64, len(vs))
+	for i, v := range vs {
+		values[i] = fn(float64(v))
+	}
+	return NewSeries(h.name, now, values, h.tags, MT_DISTRIBUTION)
+}
+
+func (h *Histogram) transform() func(float64) float64 {
+	if h.Transform == nil {
+		return identity
+	}
+	return h.Transform
+}
+
+// SnapshotValues returns the current sample stats, without flushing.
+func (h *Histogram) SnapshotValues() map[string]interface{} {
+	return sampleValues(make(map[string]interface{}, 9), h.sample, h.PercentileNamer, h.transform())
+}
+
+// sampleValues adds the stats of s to vals, without clearing, as some
+// samples are reset by Snapshot()
+func sampleValues(vals map[string]interface{}, s Sample, namer func(float64) string, fn func(float64) float64) map[string]interface{} {
+	if namer == nil {
+		namer = DefaultPercentileName
+	}
+
+	snap := NewSampleSnapshot(s.Count(), s.Values())
+	vals["count"] = snap.Count()
+	vals["min"] = fn(float64(snap.Min()))
+	vals["max"] = fn(float64(snap.Max()))
+	vals["mean"] = fn(snap.Mean())
+	vals["stddev"] = fn(snap.StdDev())
+	for i, v := range snap.Percentiles(defaultPercentiles) {
+		vals[strings.TrimPrefix(namer(defaultPercentiles[i]), ".")] = fn(v)
 	}
+	return vals
 }
@@ -0,0 +1,34 @@
+package datadog
+
+import "testing"
+
+func distributionValues(series []*Series) []float64 {
+	for _, s := range series {
+		if s.Type == MT_DISTRIBUTION {
+			return s.Points[0][1].([]float64)
+		}
+	}
+	return nil
+}
+
+func TestHistogramReportDistribution(t *testing.T) {
+	h := NewHistogram("histogram")
+	h.ReportDistribution = true
+
+	h.Update(1)
+	h.Update(2)
+	if vs := distributionValues(h.Flush(1)); len(vs) != 2 {
+		t.Errorf("expected 2 values, got %v", vs)
+	}
+	if vs := distributionValues(h.Flush(2)); vs != nil {
+		t.Errorf("expected no values to be resent, got %v", vs)
+	}
+
+	h.Update(3)
+	if vs := distributionValues(h.FlushDistribution(3)); len(vs) != 1 || vs[0] != 3 {
+		t.Errorf("expected only the new value, got %v", vs)
+	}
+	if n := h.Snapshot().Count(); n != 3 {
+		t.Errorf("expected the sample to keep all values, got %d", n)
+	}
+}
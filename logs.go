@@ -0,0 +1,121 @@
+package datadog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Limits of the logs intake
+const (
+	MaxLogEntries    = 1000
+	MaxLogBatchBytes = 5 << 20
+	MaxLogEntryBytes = 1 << 20
+)
+
+// LogEntry is a single log message for the logs intake
+type LogEntry struct {
+	Message  string `json:"message"`
+	Service  string `json:"service,omitempty"`
+	Source   string `json:"ddsource,omitempty"`
+	Tags     string `json:"ddtags,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	// Status can be e.g. "info", "warn" or "error"
+	Status string `json:"status,omitempty"`
+}
+
+// LogsUrl gets the URL of the logs intake for the configured site, e.g.
+// 'https://http-intake.logs.datadoghq.eu/api/v2/logs' for an endpoint of
+// 'https://api.datadoghq.eu/api/v1', unless LogsEndpoint is set. The URL
+// never contains the API key.
+func (c *Client) LogsUrl() string {
+	if c.LogsEndpoint != "" {
+		return strings.TrimSuffix(c.LogsEndpoint, "/") + "/api/v2/logs"
+	}
+
+	u, err := url.Parse(c.endpoint())
+	if err != nil || u.Host == "" {
+		u, _ = url.Parse(ENDPOINT)
+	}
+
+	site := u.Host
+	for _, prefix := range []string{"api.", "app."} {
+		site = strings.TrimPrefix(site, prefix)
+	}
+	return u.Scheme + "://http-intake.logs." + site + "/api/v2/logs"
+}
+
+// PostLogs posts log entries to the Datadog logs intake. Entries without a
+// hostname are assigned the client's host. Entries are split into batches
+// within the intake's limits, entries exceeding `MaxLogEntryBytes` are
+// skipped and reported as an error.
+func (c *Client) PostLogs(entries []*LogEntry) error {
+	return c.PostLogsContext(context.Background(), entries)
+}
+
+// PostLogsContext is like PostLogs, but aborts the requests when ctx
+// is cancelled.
+func (c *Client) PostLogsContext(ctx context.Context, entries []*LogEntry) error {
+	var errs []error
+	var batch []json.RawMessage
+	size := 2
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := c.postLogs(ctx, batch); err != nil {
+			errs = append(errs, err)
+		}
+		batch, size = batch[:0], 2
+	}
+
+	for _, e := range entries {
+		if e.Hostname == "" {
+			ec := *e
+			ec.Hostname = c.Host
+			e = &ec
+		}
+
+		raw, err := json.Marshal(e)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(raw) > MaxLogEntryBytes {
+			errs = append(errs, fmt.Errorf("Datadog log entry of %d bytes exceeds limit", len(raw)))
+			continue
+		}
+
+		if len(batch) == MaxLogEntries || size+len(raw)+1 > MaxLogBatchBytes {
+			flush()
+		}
+		batch = append(batch, raw)
+		size += len(raw) + 1
+	}
+	flush()
+
+	return newMultiError(errs)
+}
+
+func (c *Client) postLogs(ctx context.Context, batch []json.RawMessage) error {
+	body := new(bytes.Buffer)
+	body.WriteByte('[')
+	for i, raw := range batch {
+		if i > 0 {
+			body.WriteByte(',')
+		}
+		body.Write(raw)
+	}
+	body.WriteByte(']')
+
+	req, err := c.newRequest("POST", c.LogsUrl(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("DD-API-KEY", c.ApiKey)
+	return c.exchange(req.WithContext(ctx), nil)
+}
@@ -0,0 +1,60 @@
+package datadog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogsUrl(t *testing.T) {
+	for endpoint, expected := range map[string]string{
+		"":                                "https://http-intake.logs.datadoghq.com/api/v2/logs",
+		"https://api.datadoghq.eu/api/v1": "https://http-intake.logs.datadoghq.eu/api/v2/logs",
+		"https://app.datadoghq.com/":      "https://http-intake.logs.datadoghq.com/api/v2/logs",
+	} {
+		if url := New("host", "key", WithEndpoint(endpoint)).LogsUrl(); url != expected {
+			t.Errorf("endpoint %q: expected %s, got %s", endpoint, expected, url)
+		}
+	}
+}
+
+func TestPostLogsBatches(t *testing.T) {
+	var entries, requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []LogEntry
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Fatal(err)
+		}
+		if len(batch) > MaxLogEntries {
+			t.Errorf("expected at most %d entries per batch, got %d", MaxLogEntries, len(batch))
+		}
+		if batch[0].Hostname != "host" || r.Header.Get("DD-API-KEY") != "key" {
+			t.Errorf("expected the client's host and API key, got %q", batch[0].Hostname)
+		}
+		entries += len(batch)
+		requests++
+		w.WriteHeader(202)
+	}))
+	defer srv.Close()
+
+	c := New("host", "key")
+	c.LogsEndpoint = srv.URL
+
+	logs := make([]*LogEntry, 2*MaxLogEntries+500)
+	for i := range logs {
+		logs[i] = &LogEntry{Message: "message"}
+	}
+	logs = append(logs, &LogEntry{Message: strings.Repeat("x", MaxLogEntryBytes)})
+
+	if err := c.PostLogs(logs); err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+		t.Errorf("expected the oversized entry to be reported, got %v", err)
+	}
+	if entries != 2*MaxLogEntries+500 || requests != 3 {
+		t.Errorf("expected all other entries in 3 batches, got %d entries in %d", entries, requests)
+	}
+	if logs[0].Hostname != "" {
+		t.Error("expected entries not to be modified")
+	}
+}
@@ -0,0 +1,16 @@
+package datadog
+
+type MetricMetadata struct {
+	// Metric type can be "gauge", "rate" or "count"
+	Type string `json:"type,omitempty"`
+	// Unit of the metric, e.g. "byte" or "second"
+	Unit string `json:"unit,omitempty"`
+	// Per-unit of the metric, e.g. "second" for bytes per second
+	PerUnit string `json:"per_unit,omitempty"`
+	// A human readable description
+	Description string `json:"description,omitempty"`
+	// A short name to display in graphs
+	ShortName string `json:"short_name,omitempty"`
+	// StatsD flush interval of the metric in seconds, if applicable
+	StatsdInterval int `json:"statsd_interval,omitempty"`
+}
@@ -1,24 +1,58 @@
 package datadog
 
 import (
-	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// MeterOptions configure a meter
+type MeterOptions struct {
+	// WarmupTicks suppresses the moving average rates until the meter was
+	// ticked at least the given number of times, i.e. until the averages
+	// have converged. Ticks occur every 5s.
+	WarmupTicks int
+
+	// WarmupMeanRate reports the mean rate in place of the moving averages
+	// during warm-up, instead of omitting them
+	WarmupMeanRate bool
+}
+
 // NewMeter creates a new meter
 func NewMeter(name string, tags ...string) *Meter {
+	return NewMeterWithOptions(name, MeterOptions{}, tags...)
+}
+
+// NewMeterM is like NewMeter, but with tags converted by TagsFromMap
+func NewMeterM(name string, tags map[string]string) *Meter {
+	return NewMeter(name, TagsFromMap(tags)...)
+}
+
+// NewMeterWithOptions creates a new meter with custom options
+func NewMeterWithOptions(name string, opts MeterOptions, tags ...string) *Meter {
 	m := &Meter{
 		BaseMetric: BaseMetric{name: name, tags: tags},
+		opts:       opts,
 		a1:         NewEWMA1(),
 		a5:         NewEWMA5(),
 		a15:        NewEWMA15(),
-		startTime:  time.Now(),
+		startTime:  clock.Now(),
 	}
 	arbiter.add(m)
 	return m
 }
 
+// FetchMeterWithOptions returns or registers a new one
+func FetchMeterWithOptions(rep *MetricReporter, name string, opts MeterOptions, tags ...string) *Meter {
+	return rep.Fetch(func() Metric { return NewMeterWithOptions(name, opts, tags...) }, name, tags...).(*Meter)
+}
+
+// RegisterMeterWithOptions registers a meter with custom options
+func RegisterMeterWithOptions(rep *MetricReporter, name string, opts MeterOptions, tags ...string) *Meter {
+	m := NewMeterWithOptions(name, opts, tags...)
+	rep.Register(m)
+	return m
+}
+
 // FetchMeter returns or registers a new one
 func FetchMeter(rep *MetricReporter, name string, tags ...string) *Meter {
 	return rep.Fetch(func() Metric { return NewMeter(name, tags...) }, name, tags...).(*Meter)
@@ -31,16 +65,38 @@ func RegisterMeter(rep *MetricReporter, name string, tags ...string) *Meter {
 	return m
 }
 
-// Meter is the standard implementation of a Meter.
+func (m *Meter) withTags(name string, tags []string) Metric { return m.like(name, tags) }
+
+// like creates a meter with the options of m
+func (m *Meter) like(name string, tags []string) *Meter {
+	mm := NewMeterWithOptions(name, m.opts, tags...)
+	mm.SampleRate = m.SampleRate
+	return mm
+}
+
+// Meter is the standard implementation of a Meter. Rates are published
+// atomically on each tick, so readers never block.
 type Meter struct {
 	BaseMetric
-	lock sync.Mutex
+	activity
 
 	count     int64
+	ticks     int64
 	startTime time.Time
+	opts      MeterOptions
+
+	snapshot    atomic.Value // *MeterRates
+	a1, a5, a15 *EWMA
+
+	// SampleRate is the fraction of events marked, e.g. 0.1 if only every
+	// tenth event is marked. Flushed rates are scaled up by 1/SampleRate,
+	// see Counter.SampleRate. Zero or one disables scaling.
+	SampleRate float64
+}
 
-	rate1, rate5, rate15, rateMean float64
-	a1, a5, a15                    *EWMA
+// MeterRates is a consistent, point-in-time view of a meter's rates
+type MeterRates struct {
+	Rate1, Rate5, Rate15, RateMean float64
 }
 
 // Count returns the number of events recorded.
@@ -51,63 +107,91 @@ func (m *Meter) Count() int64 {
 // Mark records the occurance of n events.
 func (m *Meter) Mark(n int64) {
 	atomic.AddInt64(&m.count, n)
+	m.touch()
 	m.a1.Update(n)
 	m.a5.Update(n)
 	m.a15.Update(n)
 }
 
-// Rate1 returns the one-minute moving average rate of events per second.
-func (m *Meter) Rate1() float64 {
-	m.lock.Lock()
-	rate := m.rate1
-	m.lock.Unlock()
-	return rate
+// Warm reports whether the meter's warm-up period, if any, has passed.
+func (m *Meter) Warm() bool {
+	return atomic.LoadInt64(&m.ticks) >= int64(m.opts.WarmupTicks)
 }
 
-// Rate5 returns the five-minute moving average rate of events per second.
-func (m *Meter) Rate5() float64 {
-	m.lock.Lock()
-	rate := m.rate5
-	m.lock.Unlock()
-	return rate
+// Rates returns the rates published by the most recent tick, without locking.
+func (m *Meter) Rates() MeterRates {
+	if r, ok := m.snapshot.Load().(*MeterRates); ok {
+		return *r
+	}
+	return MeterRates{}
 }
 
+// Rate1 returns the one-minute moving average rate of events per second.
+func (m *Meter) Rate1() float64 { return m.Rates().Rate1 }
+
+// Rate5 returns the five-minute moving average rate of events per second.
+func (m *Meter) Rate5() float64 { return m.Rates().Rate5 }
+
 // Rate15 returns the fifteen-minute moving average rate of events per second.
-func (m *Meter) Rate15() float64 {
-	m.lock.Lock()
-	rate := m.rate15
-	m.lock.Unlock()
-	return rate
-}
+func (m *Meter) Rate15() float64 { return m.Rates().Rate15 }
 
 // RateMean returns the meter's mean rate of events per second.
-func (m *Meter) RateMean() float64 {
-	m.lock.Lock()
-	rateMean := m.rateMean
-	m.lock.Unlock()
-	return rateMean
-}
+func (m *Meter) RateMean() float64 { return m.Rates().RateMean }
 
 func (m *Meter) tick() {
 	m.a1.Tick()
 	m.a5.Tick()
 	m.a15.Tick()
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	m.rate1 = m.a1.Rate()
-	m.rate5 = m.a5.Rate()
-	m.rate15 = m.a15.Rate()
-	m.rateMean = float64(m.Count()) / time.Since(m.startTime).Seconds()
+	rates := &MeterRates{
+		Rate1:    m.a1.Rate(),
+		Rate5:    m.a5.Rate(),
+		Rate15:   m.a15.Rate(),
+		RateMean: m.RateMean(),
+	}
+	if elapsed := clock.Now().Sub(m.startTime).Seconds(); elapsed > 0 {
+		rates.RateMean = float64(m.Count()) / elapsed
+	}
+	m.snapshot.Store(rates)
+	atomic.AddInt64(&m.ticks, 1)
 }
 
 // Flush returns series and resets counter
 func (m *Meter) Flush(now int64) []*Series {
-	return []*Series{
-		NewSeries(m.name+".rate", now, m.RateMean(), m.tags, MT_GAUGE),
-		NewSeries(m.name+".rate1", now, m.Rate1(), m.tags, MT_GAUGE),
-		NewSeries(m.name+".rate5", now, m.Rate5(), m.tags, MT_GAUGE),
-		NewSeries(m.name+".rate15", now, m.Rate15(), m.tags, MT_GAUGE),
+	m.untouch()
+	return m.rates(NewSeriesBuilder(m.name, now, m.tags)).Series()
+}
+
+// rates adds the rate series to b
+func (m *Meter) rates(b *SeriesBuilder) *SeriesBuilder {
+	r := m.Rates()
+	if scale := sampleScale(m.SampleRate); scale != 1 {
+		r.Rate1, r.Rate5, r.Rate15, r.RateMean = r.Rate1*scale, r.Rate5*scale, r.Rate15*scale, r.RateMean*scale
+	}
+	b.Gauge(".rate", r.RateMean)
+	if !m.Warm() {
+		if !m.opts.WarmupMeanRate {
+			return b
+		}
+		r.Rate1, r.Rate5, r.Rate15 = r.RateMean, r.RateMean, r.RateMean
 	}
+	return b.
+		Gauge(".rate1", r.Rate1).
+		Gauge(".rate5", r.Rate5).
+		Gauge(".rate15", r.Rate15)
+}
+
+// SnapshotValues returns the current count and rates, without flushing.
+func (m *Meter) SnapshotValues() map[string]interface{} {
+	return m.snapshotValues(make(map[string]interface{}, 5))
+}
+
+func (m *Meter) snapshotValues(vals map[string]interface{}) map[string]interface{} {
+	r := m.Rates()
+	vals["count"] = m.Count()
+	vals["rate"] = r.RateMean
+	vals["rate1"] = r.Rate1
+	vals["rate5"] = r.Rate5
+	vals["rate15"] = r.Rate15
+	return vals
 }
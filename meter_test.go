@@ -0,0 +1,63 @@
+package datadog
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMeterSampleRate(t *testing.T) {
+	fc := NewFakeClock(time.Unix(1000, 0))
+	SetClock(fc)
+	defer SetClock(nil)
+
+	// tick manually only
+	m := NewMeter("sampled")
+	arbiter.remove(m)
+	m.SampleRate = 0.5
+	m.Mark(10)
+	fc.Advance(5 * time.Second)
+	m.tick()
+
+	for _, s := range m.Flush(1005) {
+		if s.Metric == "sampled.rate" && s.Points[0][1] != 2*m.RateMean() {
+			t.Errorf("expected mean rate scaled to %v, got %v", 2*m.RateMean(), s.Points[0][1])
+		}
+	}
+	if m.RateMean() == 0 {
+		t.Error("expected a mean rate")
+	}
+}
+
+// BenchmarkMeterRatesTicking measures readers while another goroutine ticks
+// the meter continuously.
+func BenchmarkMeterRatesTicking(b *testing.B) {
+	m := NewMeter("meter")
+	defer arbiter.remove(m)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				m.Mark(1)
+				m.tick()
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.Rate1()
+		}
+	})
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+}
@@ -2,16 +2,55 @@ package datadog
 
 import (
 	"sort"
-	"strings"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	MT_COUNTER = "counter"
 	MT_GAUGE   = "gauge"
+	MT_RATE    = "rate"
+
+	// Distributions are aggregated globally by Datadog and submitted to a
+	// separate endpoint. Each point holds a list of raw values.
+	MT_DISTRIBUTION = "distribution"
+)
+
+// MetricType is a typed alternative to the MT_* series types
+type MetricType int
+
+const (
+	// TypeGauge reports the value at the time of the flush, e.g. a queue
+	// length. Only the last value per interval is kept by Datadog.
+	TypeGauge MetricType = iota
+	// TypeCounter reports a total, e.g. of requests since startup, or the
+	// number of events per flush for flash counters. Counters graph as
+	// spikes at each flush, unless normalised with a rollup.
+	TypeCounter
+	// TypeRate reports a per-second rate over an interval, which Datadog
+	// needs to normalise the value. Rates graph smoothly. Build rate series
+	// with NewRateSeries.
+	TypeRate
+	// TypeDistribution reports raw values, which Datadog aggregates
+	// globally, across hosts
+	TypeDistribution
 )
 
+// String returns the series type accepted by the API, e.g. "gauge"
+func (mt MetricType) String() string {
+	switch mt {
+	case TypeCounter:
+		return MT_COUNTER
+	case TypeRate:
+		return MT_RATE
+	case TypeDistribution:
+		return MT_DISTRIBUTION
+	}
+	return MT_GAUGE
+}
+
 // An abstract meter
 type Metric interface {
 	// Name returns the name
@@ -25,22 +64,142 @@ type Metric interface {
 
 // Abstract base metric
 type BaseMetric struct {
-	name string
-	tags []string
+	name  string
+	tags  []string
+	host  string
+	mtype string
+
+	hostless bool
 }
 
 func (m *BaseMetric) Name() string   { return m.name }
 func (m *BaseMetric) Tags() []string { return m.tags }
+func (m *BaseMetric) Host() string   { return m.host }
+
+// SetHost overrides the reporter's host for all series of this metric. It
+// should be called before the metric is registered.
+func (m *BaseMetric) SetHost(host string) { m.host = host }
+
+// Hostless reports whether series are submitted without a host
+func (m *BaseMetric) Hostless() bool { return m.hostless }
+
+// SetHostless submits all series of this metric without a host, e.g. for
+// application-wide metrics which should not be tied to a single host. It
+// should be called before the metric is registered.
+func (m *BaseMetric) SetHostless(hostless bool) { m.hostless = hostless }
+
+// MetricType returns the metric type override, if set
+func (m *BaseMetric) MetricType() string { return m.mtype }
+
+// SetMetricType overrides the type of all series of this metric, e.g. to
+// report a gauge as MT_COUNTER. This is a low-level escape hatch, use with
+// care. Distribution series keep their type, as they are submitted to a
+// separate endpoint. It should be called before the metric is registered.
+func (m *BaseMetric) SetMetricType(mt string) { m.mtype = mt }
+
+func (m *BaseMetric) base() *BaseMetric { return m }
+
+// copyOverrides copies the host and type overrides of o
+func (m *BaseMetric) copyOverrides(o *BaseMetric) {
+	m.host, m.mtype, m.hostless = o.host, o.mtype, o.hostless
+}
+
+// baser is implemented by all metrics embedding BaseMetric
+type baser interface {
+	base() *BaseMetric
+}
+
+// tagger is implemented by metrics supported by Tagged. withTags creates an
+// unregistered metric with the configuration of the receiver, but with the
+// given name and tags.
+type tagger interface {
+	withTags(name string, tags []string) Metric
+}
+
+// hoster is implemented by metrics which may override the reporter's host
+type hoster interface {
+	Host() string
+}
+
+// hostlesser is implemented by metrics which may be submitted without a host
+type hostlesser interface {
+	Hostless() bool
+}
+
+// typer is implemented by metrics which may override their series type
+type typer interface {
+	MetricType() string
+}
+
+// activity tracks whether a metric was updated since its last flush
+type activity struct{ dirty int32 }
+
+func (a *activity) touch()     { atomic.StoreInt32(&a.dirty, 1) }
+func (a *activity) untouch()   { atomic.StoreInt32(&a.dirty, 0) }
+func (a *activity) idle() bool { return atomic.LoadInt32(&a.dirty) == 0 }
+
+// idler is implemented by metrics which can tell whether they were
+// updated since they were last flushed
+type idler interface {
+	idle() bool
+}
 
 // MetricID
 type MetricID string
 
-// NewMetricID generates a unique metric ID using name and tags
+// NewMetricID generates a unique metric ID using name and tags. The name and
+// each tag are length-prefixed, so distinct tag sets always yield distinct
+// IDs, even if tags contain delimiters. The given tags are not modified.
 func NewMetricID(name string, tags []string) string {
+	tags = joinTags(tags)
 	sort.Strings(tags)
-	return name + "|" + strings.Join(tags, ",")
+
+	id := make([]byte, 0, len(name)+8*(len(tags)+1))
+	id = appendIDPart(id, name)
+	id = append(id, '|')
+	for _, tag := range tags {
+		id = appendIDPart(id, tag)
+	}
+	return string(id)
+}
+
+func appendIDPart(id []byte, part string) []byte {
+	id = strconv.AppendInt(id, int64(len(part)), 10)
+	id = append(id, ':')
+	return append(id, part...)
 }
 
+// joinTags returns a new slice containing tags followed by extra, leaving
+// the backing array of tags untouched
+func joinTags(tags []string, extra ...string) []string {
+	joined := make([]string, 0, len(tags)+len(extra))
+	joined = append(joined, tags...)
+	return append(joined, extra...)
+}
+
+// TagsFromMap converts tags to `key:value` strings, sorted by key. Keys with
+// empty values are converted to bare `key` tags.
+func TagsFromMap(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	conv := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if v := tags[k]; v != "" {
+			conv = append(conv, k+":"+v)
+		} else {
+			conv = append(conv, k)
+		}
+	}
+	return conv
+}
+
+// identity is the default flush value transformation
+func identity(v float64) float64 { return v }
+
 // Periodic metric arbiter
 // Ticks metrics on the scheduled intervals
 
@@ -52,16 +211,18 @@ type tickableMetric interface {
 type tickableArbiter struct {
 	sync.Mutex
 	started bool
+	stop    chan struct{}
 	metrics []tickableMetric
 }
 
 var arbiter = new(tickableArbiter)
 
-func (ta *tickableArbiter) loop() {
-	ticker := time.NewTicker(5e9)
+func (ta *tickableArbiter) loop(ticks <-chan time.Time, stop <-chan struct{}) {
 	for {
 		select {
-		case <-ticker.C:
+		case <-stop:
+			return
+		case <-ticks:
 			ta.Lock()
 			for _, metric := range ta.metrics {
 				metric.tick()
@@ -77,7 +238,46 @@ func (ta *tickableArbiter) add(m tickableMetric) {
 
 	ta.metrics = append(ta.metrics, m)
 	if !ta.started {
-		ta.started = true
-		go ta.loop()
+		ta.start()
+	}
+}
+
+// start runs a new loop on the ticks of the package clock. The lock must be
+// held.
+func (ta *tickableArbiter) start() {
+	ta.started = true
+	ta.stop = make(chan struct{})
+	go ta.loop(clock.Tick(5e9), ta.stop)
+}
+
+// restart moves a running loop to the current package clock
+func (ta *tickableArbiter) restart() {
+	ta.Lock()
+	defer ta.Unlock()
+
+	if ta.started {
+		close(ta.stop)
+		ta.start()
 	}
 }
+
+func (ta *tickableArbiter) remove(m tickableMetric) {
+	ta.Lock()
+	defer ta.Unlock()
+
+	for i, mm := range ta.metrics {
+		if mm == m {
+			last := len(ta.metrics) - 1
+			ta.metrics[i] = ta.metrics[last]
+			ta.metrics[last] = nil
+			ta.metrics = ta.metrics[:last]
+			return
+		}
+	}
+}
+
+// ValuesSnapshotter is implemented by metrics which can report their current
+// scalar values without flushing, keyed by series suffix, e.g. "count"
+type ValuesSnapshotter interface {
+	SnapshotValues() map[string]interface{}
+}
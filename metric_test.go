@@ -0,0 +1,22 @@
+package datadog
+
+import "testing"
+
+func TestNewMetricIDCollision(t *testing.T) {
+	cases := [][2][]string{
+		{{"a,b"}, {"a", "b"}},
+		{{"a|b"}, {"a", "b"}},
+		{{"1:a"}, {"1:", "a"}},
+	}
+	for _, c := range cases {
+		if a, b := NewMetricID("m", c[0]), NewMetricID("m", c[1]); a == b {
+			t.Errorf("tags %q and %q collide as %q", c[0], c[1], a)
+		}
+	}
+	if a, b := NewMetricID("m|a", nil), NewMetricID("m", []string{"a"}); a == b {
+		t.Errorf("name and tags collide as %q", a)
+	}
+	if a, b := NewMetricID("m", []string{"b", "a"}), NewMetricID("m", []string{"a", "b"}); a != b {
+		t.Errorf("expected tag order to be ignored, got %q and %q", a, b)
+	}
+}
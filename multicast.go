@@ -0,0 +1,76 @@
+package datadog
+
+import (
+	"context"
+	"sync"
+)
+
+// MulticastClient submits the same data to multiple Datadog clients, e.g. to
+// ship metrics to several organisations from a single registry. A failure to
+// deliver to one client does not affect the others.
+type MulticastClient struct {
+	Clients []*Client
+}
+
+// NewMulticast creates a new multicast client. The host of the first client is
+// used for series reported via `Reporter`.
+func NewMulticast(clients ...*Client) *MulticastClient {
+	return &MulticastClient{Clients: clients}
+}
+
+// PostSeries posts series data to all clients concurrently. Errors are
+// aggregated in a `MultiError` if more than one client fails.
+func (m *MulticastClient) PostSeries(series []*Series) error {
+	return m.PostSeriesContext(context.Background(), series)
+}
+
+// PostSeriesContext is like PostSeries, but aborts all requests when ctx
+// is cancelled.
+func (m *MulticastClient) PostSeriesContext(ctx context.Context, series []*Series) error {
+	return m.each(func(c *Client) error { return c.PostSeriesContext(ctx, series) })
+}
+
+// PostEvent posts a single event to all clients concurrently. Errors are
+// aggregated in a `MultiError` if more than one client fails.
+func (m *MulticastClient) PostEvent(event *Event) error {
+	return m.each(func(c *Client) error {
+		ev := *event
+		return c.PostEvent(&ev)
+	})
+}
+
+// Reporter creates a `MetricReporter` which reports to all clients. The
+// returned reporter will not be started.
+func (m *MulticastClient) Reporter(tags ...string) *MetricReporter {
+	return newReporter(m, tags...)
+}
+
+// Private host accessor, used by reporters
+func (m *MulticastClient) hostname() string {
+	if len(m.Clients) == 0 {
+		return ""
+	}
+	return m.Clients[0].Host
+}
+
+func (m *MulticastClient) each(fn func(*Client) error) error {
+	errs := make([]error, len(m.Clients))
+
+	var wg sync.WaitGroup
+	for i, c := range m.Clients {
+		wg.Add(1)
+		go func(i int, c *Client) {
+			defer wg.Done()
+			errs[i] = fn(c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	failed := errs[:0]
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return newMultiError(failed)
+}
@@ -0,0 +1,56 @@
+package datadog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMulticastPartialFailure(t *testing.T) {
+	var delivered int32
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&delivered, 1)
+		w.WriteHeader(202)
+	}))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(403)
+	}))
+	defer failing.Close()
+
+	m := NewMulticast(New("first", "a", WithEndpoint(failing.URL)), New("second", "b", WithEndpoint(ok.URL)))
+	rep := m.Reporter()
+	RegisterGauge(rep, "g").Update(1)
+
+	if err := rep.Report(); err == nil {
+		t.Error("expected the failing client's error")
+	}
+	if n := atomic.LoadInt32(&delivered); n != 1 {
+		t.Errorf("expected delivery to the healthy client, got %d requests", n)
+	}
+	if s := rep.Series(); len(s) != 1 || s[0].Host != "first" {
+		t.Errorf("expected series with the first client's host, got %v", s)
+	}
+}
+
+func TestMulticastEventsAreCopied(t *testing.T) {
+	keys := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys <- r.URL.Query().Get("api_key")
+		w.WriteHeader(202)
+	}))
+	defer srv.Close()
+
+	event := &Event{Title: "deploy"}
+	m := NewMulticast(New("first", "a", WithEndpoint(srv.URL)), New("second", "b", WithEndpoint(srv.URL)))
+	if err := m.PostEvent(event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Host != "" {
+		t.Errorf("expected the event to be copied for each client, host set to %s", event.Host)
+	}
+	if a, b := <-keys, <-keys; a == b {
+		t.Errorf("expected one request per client, got keys %s and %s", a, b)
+	}
+}
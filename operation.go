@@ -0,0 +1,81 @@
+package datadog
+
+import "time"
+
+// Operation bundles a meter and a timer under a single name, to track both
+// the rate and the latency of an operation. Rates are flushed under the
+// operation's name, latency stats under `<name>.latency`.
+type Operation struct {
+	BaseMetric
+	meter *Meter
+	timer *Timer
+}
+
+// NewOperation creates a new operation with a default exponentially-decaying
+// latency sample
+func NewOperation(name string, unit time.Duration, tags ...string) *Operation {
+	return &Operation{
+		BaseMetric: BaseMetric{name: name, tags: tags},
+		meter:      NewMeter(name, tags...),
+		timer:      NewTimer(name+".latency", unit, tags...),
+	}
+}
+
+// FetchOperation returns or registers a new one
+func FetchOperation(rep *MetricReporter, name string, unit time.Duration, tags ...string) *Operation {
+	return rep.Fetch(func() Metric { return NewOperation(name, unit, tags...) }, name, tags...).(*Operation)
+}
+
+// RegisterOperation registers an operation
+func RegisterOperation(rep *MetricReporter, name string, unit time.Duration, tags ...string) *Operation {
+	m := NewOperation(name, unit, tags...)
+	rep.Register(m)
+	return m
+}
+
+func (o *Operation) withTags(name string, tags []string) Metric {
+	return &Operation{
+		BaseMetric: BaseMetric{name: name, tags: tags},
+		meter:      o.meter.like(name, tags),
+		timer:      o.timer.like(o.timer.name, tags),
+	}
+}
+
+// Meter returns the underlying meter
+func (o *Operation) Meter() *Meter { return o.meter }
+
+// Timer returns the underlying timer
+func (o *Operation) Timer() *Timer { return o.timer }
+
+// Clear clears the latency sample.
+func (o *Operation) Clear() { o.timer.Clear() }
+
+// Record records a single occurrence of the operation, which took d.
+func (o *Operation) Record(d time.Duration) {
+	o.meter.Mark(1)
+	o.timer.Update(d)
+}
+
+// RecordSince records a single occurrence of the operation, which started at ts.
+func (o *Operation) RecordSince(ts time.Time) { o.Record(clock.Now().Sub(ts)) }
+
+// Flush returns series
+func (o *Operation) Flush(now int64) []*Series {
+	series := o.meter.Flush(now)
+	return append(series, o.timer.latency(NewSeriesBuilder(o.name+".latency", now, o.tags)).Series()...)
+}
+
+func (o *Operation) idle() bool { return o.meter.idle() }
+
+// SnapshotValues returns the current rates and latency stats, without
+// flushing. Latency stats are prefixed with `latency.`.
+func (o *Operation) SnapshotValues() map[string]interface{} {
+	t := o.timer
+	latency := sampleValues(make(map[string]interface{}, 9), t.sample, t.PercentileNamer, func(v float64) float64 { return v / t.unit })
+
+	vals := o.meter.snapshotValues(make(map[string]interface{}, 14))
+	for k, v := range latency {
+		vals["latency."+k] = v
+	}
+	return vals
+}
@@ -0,0 +1,59 @@
+package datadog
+
+import (
+	"net/http"
+	"time"
+)
+
+// Option configures a Client, see `New`
+type Option func(*Client)
+
+// WithEndpoint sets a custom API endpoint, e.g. "https://api.datadoghq.eu/api/v1"
+func WithEndpoint(endpoint string) Option {
+	return func(c *Client) { c.Endpoint = endpoint }
+}
+
+// WithHTTPClient sets a custom HTTP client, e.g. to configure timeouts
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) { c.HTTPClient = client }
+}
+
+// WithRetry retries failed requests up to n times, waiting backoff before
+// the first retry and doubling the delay on each subsequent one
+func WithRetry(n int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.Retries = n
+		c.RetryBackoff = backoff
+	}
+}
+
+// WithCompression enables gzip compression of request bodies
+func WithCompression() Option {
+	return func(c *Client) { c.Compress = true }
+}
+
+// WithHeaderAuth sends the API key via the DD-API-KEY header
+func WithHeaderAuth() Option {
+	return func(c *Client) { c.UseHeaderAuth = true }
+}
+
+// WithAppKey sets the application key
+func WithAppKey(key string) Option {
+	return func(c *Client) { c.AppKey = key }
+}
+
+// WithSeriesV2 submits series via the v2 intake
+func WithSeriesV2() Option {
+	return func(c *Client) { c.UseSeriesV2 = true }
+}
+
+// WithInstrumentation registers metrics about the client's own requests
+// with rep, see `Client.Instrument`
+func WithInstrumentation(rep *MetricReporter) Option {
+	return func(c *Client) { c.Instrument(rep) }
+}
+
+// WithEventConcurrency sets the number of events submitted concurrently
+func WithEventConcurrency(n int) Option {
+	return func(c *Client) { c.EventConcurrency = n }
+}
@@ -0,0 +1,47 @@
+package datadog
+
+// QueryResult is the response of a timeseries query
+type QueryResult struct {
+	Status string         `json:"status"`
+	Query  string         `json:"query"`
+	From   int64          `json:"from_date"`
+	To     int64          `json:"to_date"`
+	Series []*QuerySeries `json:"series"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// QuerySeries is a single series of a query result
+type QuerySeries struct {
+	Metric      string   `json:"metric"`
+	DisplayName string   `json:"display_name"`
+	Scope       string   `json:"scope"`
+	Expression  string   `json:"expression"`
+	Aggregation string   `json:"aggr"`
+	Interval    int64    `json:"interval"`
+	Start       int64    `json:"start"`
+	End         int64    `json:"end"`
+	TagSet      []string `json:"tag_set"`
+
+	// Points are pairs of millisecond timestamps and values. Values
+	// are nil where no data was reported.
+	Points []QueryPoint `json:"pointlist"`
+}
+
+// QueryPoint is a pair of a millisecond timestamp and a (nullable) value
+type QueryPoint [2]*float64
+
+// Time returns the point's timestamp in milliseconds
+func (p QueryPoint) Time() int64 {
+	if p[0] == nil {
+		return 0
+	}
+	return int64(*p[0])
+}
+
+// Value returns the point's value and false if the value is missing
+func (p QueryPoint) Value() (float64, bool) {
+	if p[1] == nil {
+		return 0, false
+	}
+	return *p[1], true
+}
@@ -0,0 +1,45 @@
+package datadog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQueryMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/query" || q.Get("from") != "60" || q.Get("to") != "120" || q.Get("query") != "avg:a{*}" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if r.Header.Get("DD-APPLICATION-KEY") != "app" {
+			t.Error("expected the application key header")
+		}
+		w.Write([]byte(`{"status":"ok","series":[{"metric":"a","pointlist":[[60000,1.5],[90000,null]]}]}`))
+	}))
+	defer srv.Close()
+
+	c := New("host", "key", WithEndpoint(srv.URL), WithAppKey("app"))
+	res, err := c.QueryMetrics(time.Unix(60, 0), time.Unix(120, 0), "avg:a{*}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Series) != 1 || len(res.Series[0].Points) != 2 {
+		t.Fatalf("expected a series with 2 points, got %+v", res)
+	}
+
+	points := res.Series[0].Points
+	if v, ok := points[0].Value(); points[0].Time() != 60000 || !ok || v != 1.5 {
+		t.Errorf("unexpected first point %v at %d", v, points[0].Time())
+	}
+	if _, ok := points[1].Value(); ok {
+		t.Error("expected the second value to be missing")
+	}
+}
+
+func TestQueryMetricsRequiresAppKey(t *testing.T) {
+	if _, err := New("host", "key").QueryMetrics(time.Unix(0, 0), time.Unix(60, 0), "a"); err != errMissingAppKey {
+		t.Errorf("expected missing application key error, got %v", err)
+	}
+}
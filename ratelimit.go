@@ -0,0 +1,52 @@
+package datadog
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimit is the rate limit state reported by the Datadog API
+type RateLimit struct {
+	// Name of the rate limit
+	Name string
+	// Number of requests allowed per period
+	Limit int
+	// Length of the period
+	Period time.Duration
+	// Number of requests remaining in the current period
+	Remaining int
+	// Time until the current period resets
+	Reset time.Duration
+	// Time the state was received at
+	Time time.Time
+}
+
+// parseRateLimit extracts the X-RateLimit-* headers, returns nil if absent
+func parseRateLimit(h http.Header) *RateLimit {
+	if h.Get("X-RateLimit-Limit") == "" {
+		return nil
+	}
+
+	atoi := func(key string) int {
+		n, _ := strconv.Atoi(h.Get(key))
+		return n
+	}
+	return &RateLimit{
+		Name:      h.Get("X-RateLimit-Name"),
+		Limit:     atoi("X-RateLimit-Limit"),
+		Period:    time.Duration(atoi("X-RateLimit-Period")) * time.Second,
+		Remaining: atoi("X-RateLimit-Remaining"),
+		Reset:     time.Duration(atoi("X-RateLimit-Reset")) * time.Second,
+		Time:      time.Now(),
+	}
+}
+
+// retryAfter returns the delay requested by a Retry-After header in seconds
+func retryAfter(h http.Header) time.Duration {
+	n, err := strconv.Atoi(h.Get("Retry-After"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,51 @@
+package datadog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientRateLimitState(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Name", "metrics")
+		w.Header().Set("X-RateLimit-Limit", "100")
+		w.Header().Set("X-RateLimit-Period", "60")
+		w.Header().Set("X-RateLimit-Remaining", "7")
+		w.Header().Set("X-RateLimit-Reset", "12")
+		w.WriteHeader(202)
+	}))
+	defer srv.Close()
+
+	c := New("host", "key", WithEndpoint(srv.URL))
+	if rl := c.RateLimitState(); rl != nil {
+		t.Fatalf("expected no rate limit state before a request, got %+v", rl)
+	}
+	if err := c.PostSeries(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	rl := c.RateLimitState()
+	if rl == nil {
+		t.Fatal("expected rate limit state")
+	}
+	if rl.Name != "metrics" || rl.Limit != 100 || rl.Period != time.Minute || rl.Remaining != 7 || rl.Reset != 12*time.Second {
+		t.Errorf("unexpected rate limit state %+v", rl)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"3":    3 * time.Second,
+		"":     0,
+		"-1":   0,
+		"soon": 0,
+	} {
+		h := http.Header{}
+		h.Set("Retry-After", value)
+		if d := retryAfter(h); d != expected {
+			t.Errorf("Retry-After %q: expected %v, got %v", value, expected, d)
+		}
+	}
+}
@@ -3,22 +3,148 @@
 package datadog
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"log"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// seriesPoster submits series data on behalf of a reporter
+type seriesPoster interface {
+	hostname() string
+	PostSeriesContext(context.Context, []*Series) error
+}
+
 type MetricReporter struct {
-	client   *Client
+	// MaxMetrics limits the number of registered metrics. Once reached,
+	// new metrics are dropped rather than registered. Zero means unlimited.
+	MaxMetrics int
+
+	// SkipZero omits counters, meters, histograms and timers which were
+	// not updated since the previous flush. Gauges are always reported.
+	SkipZero bool
+
+	// TagInterval adds a `flush_interval:<duration>` tag to all series once
+	// the reporter is started, e.g. `flush_interval:15s`
+	TagInterval bool
+
+	// TagVersion adds a `go_datadog_version:<version>` tag with the
+	// library Version to all series
+	TagVersion bool
+
+	// SanitizeNames applies `SanitizeMetricName` to all series names
+	SanitizeNames bool
+
+	// LowercaseNames additionally lowercases names sanitized by
+	// `SanitizeNames`, see `SanitizeMetricNameLower`
+	LowercaseNames bool
+
+	// OnReport is called after every report attempt with the number of
+	// series submitted and the resulting error, if any
+	OnReport func(series int, err error)
+
+	// ReportTimeout limits the duration of each report triggered by Start.
+	// Reports exceeding it are abandoned and logged. Zero means no limit.
+	ReportTimeout time.Duration
+
+	// Filter is applied to each series at flush, after tags and host are
+	// set. It may modify the series, e.g. strip tags or rename it, or
+	// return nil to drop it.
+	Filter func(*Series) *Series
+
+	// BestEffort checks that each series can be marshalled before it is
+	// submitted. Series which fail are logged and dropped, rather than
+	// failing the entire report. See MarshalErrors.
+	BestEffort bool
+
+	// MaxSeriesBytes drops and logs individual series whose JSON encoding
+	// exceeds the given size, e.g. because of excessive tags, as they could
+	// never be submitted. Zero disables the check. See OversizedSeries.
+	MaxSeriesBytes int
+
+	// KeepReports retains the series of the last n reports for debugging,
+	// see RecentReports. Zero disables retention.
+	KeepReports int
+
+	// MaxPointAge and MaxPointLead drop points with timestamps older or
+	// further in the future than the given durations, which Datadog would
+	// reject, e.g. because of clock skew. Zero disables the check. See
+	// RejectedPoints.
+	MaxPointAge  time.Duration
+	MaxPointLead time.Duration
+
+	// Now returns the time used to timestamp flushed series, defaults to
+	// the package clock, see SetClock. All series of a report share a
+	// single timestamp.
+	Now func() time.Time
+
+	// DefaultTags is called on each flush and its tags are added to all
+	// series, e.g. for values which may change or are only known late,
+	// such as a container ID. It should be fast, or memoize its result.
+	DefaultTags func() []string
+
+	// ErrorLogInterval throttles logging of errors of started and queued reports.
+	// Identical consecutive errors are logged at most once per interval,
+	// with a count of repetitions. Zero logs every error.
+	ErrorLogInterval time.Duration
+
+	// QueueDepth enables asynchronous reports. Reports enqueue their series
+	// and return immediately, while QueueSenders goroutines, 1 by default,
+	// submit them. Errors are logged and passed to OnReport, which may then
+	// be called concurrently. When the queue is full, the oldest report is
+	// dropped, see QueueDropped. FlushNow and ReportMetric always submit
+	// synchronously. Both must be set before the first report.
+	QueueDepth   int
+	QueueSenders int
+
+	// ExpositionBuckets are the upper bounds of the `_bucket` series which
+	// `Exposition` renders for histograms and timers, in the timer's unit.
+	// Defaults to DefaultExpositionBuckets.
+	ExpositionBuckets []float64
+
+	client   seriesPoster
 	registry map[string]Metric
 	tags     []string
 	lock     sync.Mutex
+
+	reportLock sync.Mutex
+
+	typeTags map[reflect.Type][]string
+
+	dropped     int64
+	dropWarning sync.Once
+	interval    int64
+	poisoned    int64
+	rejected    int64
+	oversized   int64
+
+	recent  [][]*Series
+	aliases map[string]string
+
+	queue     chan []*Series
+	queueOnce sync.Once
+	queueDrop int64
+	pending   int64
+
+	errorLog errorLog
 }
 
 // NewReporter creates an un-started Reporter.
 // The recreated `Reporter` will not be started. Invoke `go r.Start()`
 // to enable reporting.
 func NewReporter(c *Client, t ...string) *MetricReporter {
+	return newReporter(c, t...)
+}
+
+func newReporter(c seriesPoster, t ...string) *MetricReporter {
 	return &MetricReporter{
 		client:   c,
 		tags:     t,
@@ -34,28 +160,118 @@ func NewReporter(c *Client, t ...string) *MetricReporter {
 // absolute, not based on the finish time of the previous event. They are,
 // however, serial.
 func (rep *MetricReporter) Start(d time.Duration) {
+	atomic.StoreInt64(&rep.interval, int64(d))
 	ticker := time.NewTicker(d)
 	for _ = range ticker.C {
-		if err := rep.Report(); err != nil {
-			log.Printf("Datadog series error: %s", err.Error())
-		}
+		rep.errorLog.log(rep.reportWithTimeout(), rep.ErrorLogInterval)
+	}
+}
+
+// StartJittered is like Start, but delays the first tick by a random duration
+// of up to jitter, spreading reports of simultaneously started processes.
+func (rep *MetricReporter) StartJittered(d, jitter time.Duration) {
+	if jitter > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(jitter))))
+	}
+	rep.Start(d)
+}
+
+// StartAligned is like Start, but delays the first tick until the next
+// multiple of d since the Unix epoch, so reports of all processes using the
+// same interval are roughly aligned to wall-clock boundaries.
+func (rep *MetricReporter) StartAligned(d time.Duration) {
+	if d > 0 {
+		time.Sleep(d - time.Duration(time.Now().UnixNano()%int64(d)))
+	}
+	rep.Start(d)
+}
+
+func (rep *MetricReporter) reportWithTimeout() error {
+	ctx := context.Background()
+	if rep.ReportTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rep.ReportTimeout)
+		defer cancel()
 	}
+	return rep.ReportContext(ctx)
 }
 
-// Register registers a single metric
+// Register registers a single metric. A metric with the same ID is
+// replaced and no longer ticked, like a metric which is dropped.
 func (rep *MetricReporter) Register(m Metric) {
+	id := NewMetricID(m.Name(), m.Tags())
+
 	rep.lock.Lock()
-	rep.registry[NewMetricID(m.Name(), m.Tags())] = m
+	rep.put(id, m)
 	rep.lock.Unlock()
 }
 
+// RegisterAll registers multiple metrics at once and returns the number of
+// metrics registered. Like Register, it replaces existing metrics with the
+// same ID.
+func (rep *MetricReporter) RegisterAll(metrics ...Metric) int {
+	ids := make([]string, len(metrics))
+	for i, m := range metrics {
+		ids[i] = NewMetricID(m.Name(), m.Tags())
+	}
+
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	n := 0
+	for i, m := range metrics {
+		if rep.put(ids[i], m) {
+			n++
+		}
+	}
+	return n
+}
+
+// put registers m under id, if admitted, and releases the metric it
+// replaces or m itself, if dropped. The lock must be held.
+func (rep *MetricReporter) put(id string, m Metric) bool {
+	if !rep.admit(id) {
+		release(m)
+		return false
+	}
+	if old, ok := rep.registry[id]; ok && old != m {
+		release(old)
+	}
+	rep.registry[id] = m
+	return true
+}
+
+// SetTypeTags sets default tags for all metrics of the same Go type as kind,
+// merged into their series at flush. A nil pointer is sufficient, e.g.
+//
+//	rep.SetTypeTags((*datadog.Timer)(nil), "metric_type:timer")
+//
+// Tags replace any previously set for the type.
+func (rep *MetricReporter) SetTypeTags(kind Metric, tags ...string) {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	if rep.typeTags == nil {
+		rep.typeTags = make(map[reflect.Type][]string)
+	}
+	rep.typeTags[reflect.TypeOf(kind)] = tags
+}
+
 // Get returns a registered metric
 func (rep *MetricReporter) Get(name string, tags ...string) Metric {
 	return rep.GetByID(NewMetricID(name, tags))
 }
 
-// Fetch returns a registered metric or registers a new one via given fallback
+// Fetch returns a registered metric or registers a new one via given fallback.
+// If the registry is full, the fallback metric is returned unregistered.
 func (rep *MetricReporter) Fetch(fallback func() Metric, name string, tags ...string) Metric {
+	m, _ := rep.FetchNew(fallback, name, tags...)
+	return m
+}
+
+// FetchNew is like Fetch, but also reports whether the metric was created
+// by the fallback, e.g. to run one-time setup for new metrics.
+func (rep *MetricReporter) FetchNew(fallback func() Metric, name string, tags ...string) (Metric, bool) {
 	id := NewMetricID(name, tags)
 
 	rep.lock.Lock()
@@ -63,10 +279,116 @@ func (rep *MetricReporter) Fetch(fallback func() Metric, name string, tags ...st
 
 	val, ok := rep.registry[id]
 	if !ok {
+		admitted := rep.admit(id)
 		val = fallback()
-		rep.registry[id] = val
+		if admitted {
+			rep.registry[id] = val
+		} else {
+			release(val)
+		}
+	}
+	return val, !ok
+}
+
+// FetchAs is like Fetch, but returns an error instead of a metric if the
+// registered metric is not of the same Go type as kind, e.g. because the
+// same name and tags are used for different types of metrics. A nil pointer
+// is sufficient as kind, e.g.
+//
+//	m, err := rep.FetchAs((*datadog.Timer)(nil), fallback, "jobs.latency")
+func (rep *MetricReporter) FetchAs(kind Metric, fallback func() Metric, name string, tags ...string) (Metric, error) {
+	m := rep.Fetch(fallback, name, tags...)
+	if want := reflect.TypeOf(kind); reflect.TypeOf(m) != want {
+		return nil, fmt.Errorf("Datadog metric %s [%s] is a %T, not a %s", name, strings.Join(tags, ","), m, want)
+	}
+	return m, nil
+}
+
+// Dropped returns the number of times a metric was not registered because
+// `MaxMetrics` was exceeded
+func (rep *MetricReporter) Dropped() int64 {
+	return atomic.LoadInt64(&rep.dropped)
+}
+
+// MarshalErrors returns the number of series dropped in `BestEffort` mode,
+// because they could not be marshalled
+func (rep *MetricReporter) MarshalErrors() int64 {
+	return atomic.LoadInt64(&rep.poisoned)
+}
+
+// OversizedSeries returns the number of series dropped, because they
+// exceeded `MaxSeriesBytes`
+func (rep *MetricReporter) OversizedSeries() int64 {
+	return atomic.LoadInt64(&rep.oversized)
+}
+
+// RejectedPoints returns the number of points dropped, because their
+// timestamps were outside of the `MaxPointAge` and `MaxPointLead` window
+func (rep *MetricReporter) RejectedPoints() int64 {
+	return atomic.LoadInt64(&rep.rejected)
+}
+
+// RecentReports returns the series submitted by the most recent reports,
+// oldest first, if `KeepReports` is set. Series must not be modified.
+func (rep *MetricReporter) RecentReports() [][]*Series {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	return append([][]*Series(nil), rep.recent...)
+}
+
+// Tagged returns a metric with the same name and type as base, but with extra
+// tags appended to the base tags. The metric is registered via `Fetch` if it
+// does not exist yet. New metrics copy the configuration of base, including
+// host and type overrides. Histograms and timers are created with a fresh
+// sample of the same kind as base. Returns nil for unsupported metric types.
+func (rep *MetricReporter) Tagged(base Metric, extra ...string) Metric {
+	name, tags := base.Name(), joinTags(base.Tags(), extra...)
+
+	t, ok := base.(tagger)
+	if !ok {
+		return nil
 	}
-	return val
+
+	return rep.Fetch(func() Metric {
+		m := t.withTags(name, tags)
+		if b, ok := base.(baser); ok {
+			m.(baser).base().copyOverrides(b.base())
+		}
+		return m
+	}, name, tags...)
+}
+
+// Alias additionally reports all series with names starting with oldPrefix
+// under newPrefix, e.g. during a migration to new metric names. Aliased
+// series are subject to `SanitizeNames` and `Filter`, like the originals.
+func (rep *MetricReporter) Alias(oldPrefix, newPrefix string) {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	if rep.aliases == nil {
+		rep.aliases = make(map[string]string)
+	}
+	rep.aliases[oldPrefix] = newPrefix
+}
+
+// RemoveAlias removes the alias for oldPrefix
+func (rep *MetricReporter) RemoveAlias(oldPrefix string) {
+	rep.lock.Lock()
+	delete(rep.aliases, oldPrefix)
+	rep.lock.Unlock()
+}
+
+// Aliases returns the registered aliases, mapping old to new prefixes
+func (rep *MetricReporter) Aliases() map[string]string {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	aliases := make(map[string]string, len(rep.aliases))
+	for k, v := range rep.aliases {
+		aliases[k] = v
+	}
+	return aliases
 }
 
 // GetByID returns a registered metric
@@ -81,29 +403,437 @@ func (rep *MetricReporter) GetByID(id string) Metric {
 	return nil
 }
 
-// Report POSTs a single series report to the Datadog API. A 200 or 202 is expected for
+// Clear removes all metrics from the registry. Removed meters and timers
+// are no longer ticked, so their rates stop updating.
+func (rep *MetricReporter) Clear() {
+	rep.lock.Lock()
+	mets := rep.registry
+	rep.registry = make(map[string]Metric)
+	rep.lock.Unlock()
+
+	for _, m := range mets {
+		release(m)
+	}
+}
+
+// Reset clears the accumulated state of all registered metrics which support
+// it, e.g. counters, histograms and timer samples, but keeps them registered.
+// Gauges and meter rates are unaffected.
+func (rep *MetricReporter) Reset() {
+	for _, m := range rep.registered() {
+		if c, ok := m.(interface{ Clear() }); ok {
+			c.Clear()
+		}
+	}
+}
+
+// SnapshotAll returns the current values of all registered metrics which
+// implement `ValuesSnapshotter`, keyed by name and tags, e.g.
+// "page.visits [page:x]". Metrics are not flushed.
+func (rep *MetricReporter) SnapshotAll() map[string]map[string]interface{} {
+	mets := rep.registered()
+	all := make(map[string]map[string]interface{}, len(mets))
+	for _, m := range mets {
+		if s, ok := m.(ValuesSnapshotter); ok {
+			all[m.Name()+" ["+strings.Join(m.Tags(), ",")+"]"] = s.SnapshotValues()
+		}
+	}
+	return all
+}
+
+// Report POSTs a single series report to the Datadog API. A 2xx response is expected for
 // this to complete without error.
 func (rep *MetricReporter) Report() error {
-	return rep.client.PostSeries(rep.Series())
+	return rep.ReportContext(context.Background())
+}
+
+// ReportContext is like Report, but aborts the submission when ctx is cancelled.
+// Reports are serialized, so concurrent calls never overlap.
+func (rep *MetricReporter) ReportContext(ctx context.Context) error {
+	rep.reportLock.Lock()
+	defer rep.reportLock.Unlock()
+
+	if rep.QueueDepth > 0 {
+		rep.enqueue(rep.prepare(rep.Series()))
+		return nil
+	}
+	return rep.submit(ctx, rep.Series())
+}
+
+// ReportMetric flushes a single registered metric and submits its series
+// immediately, e.g. for important events which should not wait for the next
+// report. Returns an error if no such metric is registered.
+func (rep *MetricReporter) ReportMetric(ctx context.Context, name string, tags ...string) error {
+	m := rep.Get(name, tags...)
+	if m == nil {
+		return fmt.Errorf("Datadog metric %s [%s] not registered", name, strings.Join(tags, ","))
+	}
+
+	rep.reportLock.Lock()
+	defer rep.reportLock.Unlock()
+
+	return rep.submit(ctx, rep.flush(nil, m, rep.newFlushContext()))
+}
+
+// submit prepares and posts series, the report lock must be held
+func (rep *MetricReporter) submit(ctx context.Context, series []*Series) error {
+	return rep.post(ctx, rep.prepare(series))
+}
+
+// prepare filters and retains series, the report lock must be held
+func (rep *MetricReporter) prepare(series []*Series) []*Series {
+	series = rep.marshallable(series)
+	rep.retain(series)
+	return series
+}
+
+func (rep *MetricReporter) post(ctx context.Context, series []*Series) error {
+	err := rep.client.PostSeriesContext(ctx, series)
+	if rep.OnReport != nil {
+		rep.OnReport(len(series), err)
+	}
+	return err
+}
+
+// enqueue adds series to the queue of asynchronous reports, dropping the
+// oldest report if the queue is full. Senders are started on first use.
+func (rep *MetricReporter) enqueue(series []*Series) {
+	rep.queueOnce.Do(func() {
+		rep.queue = make(chan []*Series, rep.QueueDepth)
+		n := rep.QueueSenders
+		if n < 1 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			go rep.send()
+		}
+	})
+
+	atomic.AddInt64(&rep.pending, 1)
+	for {
+		select {
+		case rep.queue <- series:
+			return
+		default:
+		}
+
+		select {
+		case <-rep.queue:
+			atomic.AddInt64(&rep.queueDrop, 1)
+			atomic.AddInt64(&rep.pending, -1)
+		default:
+		}
+	}
+}
+
+// send submits queued reports
+func (rep *MetricReporter) send() {
+	for series := range rep.queue {
+		rep.errorLog.log(rep.postWithTimeout(series), rep.ErrorLogInterval)
+		atomic.AddInt64(&rep.pending, -1)
+	}
+}
+
+// Drain waits until all asynchronous reports were submitted, e.g. before
+// closing the client on shutdown, or until ctx is cancelled. Reports
+// enqueued while draining are waited for too.
+func (rep *MetricReporter) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for atomic.LoadInt64(&rep.pending) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (rep *MetricReporter) postWithTimeout(series []*Series) error {
+	ctx := context.Background()
+	if rep.ReportTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rep.ReportTimeout)
+		defer cancel()
+	}
+	return rep.post(ctx, series)
+}
+
+// QueueDropped returns the number of asynchronous reports dropped, because
+// the queue was full
+func (rep *MetricReporter) QueueDropped() int64 {
+	return atomic.LoadInt64(&rep.queueDrop)
+}
+
+// FlushNow triggers an immediate out-of-band report, e.g. before shutting
+// down. It is safe to call concurrently with a started reporter, reports
+// are serialized. Unlike Report, it submits synchronously, even if
+// `QueueDepth` is set.
+func (rep *MetricReporter) FlushNow(ctx context.Context) error {
+	rep.reportLock.Lock()
+	defer rep.reportLock.Unlock()
+
+	return rep.submit(ctx, rep.Series())
 }
 
 // Series flushes each metric associated with the reporter and returns a series messages
-// with the current hostname of the `Client`.
+// with the current hostname of the `Client`, unless the metric overrides it.
+// Series are sorted by name and tags.
 func (rep *MetricReporter) Series() []*Series {
-	now := time.Now().Unix()
 	mets := rep.registered()
+	fc := rep.newFlushContext()
 
 	series := make([]*Series, 0, len(mets))
 	for _, m := range mets {
-		series = append(series, m.Flush(now)...)
+		series = rep.flush(series, m, fc)
+	}
+	sortSeries(series)
+	return series
+}
+
+// flushContext holds the reporter state applied to all metrics of a flush
+type flushContext struct {
+	now      int64
+	host     string
+	tags     []string
+	typeTags map[reflect.Type][]string
+	aliases  map[string]string
+}
+
+func (rep *MetricReporter) newFlushContext() *flushContext {
+	now := rep.Now
+	if now == nil {
+		now = clock.Now
+	}
+
+	return &flushContext{
+		now:      now().Unix(),
+		host:     rep.client.hostname(),
+		tags:     rep.reportTags(),
+		typeTags: rep.registeredTypeTags(),
+		aliases:  rep.Aliases(),
+	}
+}
+
+// reportTags returns the tags added to all series of a report
+func (rep *MetricReporter) reportTags() []string {
+	tags := rep.tags
+	if d := time.Duration(atomic.LoadInt64(&rep.interval)); rep.TagInterval && d > 0 {
+		tags = joinTags(tags, "flush_interval:"+d.String())
+	}
+	if rep.TagVersion {
+		tags = joinTags(tags, "go_datadog_version:"+Version)
+	}
+	if rep.DefaultTags != nil {
+		tags = joinTags(tags, rep.DefaultTags()...)
+	}
+	return tags
+}
+
+// flush flushes m and appends its series to dst
+func (rep *MetricReporter) flush(dst []*Series, m Metric, fc *flushContext) []*Series {
+	if i, ok := m.(idler); ok && rep.SkipZero && i.idle() {
+		return dst
+	}
+
+	mhost := fc.host
+	if h, ok := m.(hoster); ok && h.Host() != "" {
+		mhost = h.Host()
+	}
+	if h, ok := m.(hostlesser); ok && h.Hostless() {
+		mhost = ""
+	}
+	var mtype string
+	if t, ok := m.(typer); ok {
+		mtype = t.MetricType()
+	}
+	mtags := fc.tags
+	if extra := fc.typeTags[reflect.TypeOf(m)]; len(extra) != 0 {
+		mtags = joinTags(fc.tags, extra...)
+	}
+
+	for _, s := range safeFlush(m, fc.now) {
+		// copy, as series may share the metric's tags slice
+		s.Tags = joinTags(s.Tags, mtags...)
+		s.Host = mhost
+		// distribution series are submitted to a separate endpoint, which
+		// only accepts distributions
+		if mtype != "" && s.Type != MT_DISTRIBUTION {
+			s.Type = mtype
+		}
+		if !rep.withinWindow(s, fc.now) {
+			continue
+		}
+
+		for _, s := range withAliases(s, fc.aliases) {
+			if rep.SanitizeNames && rep.LowercaseNames {
+				s.Metric = SanitizeMetricNameLower(s.Metric)
+			} else if rep.SanitizeNames {
+				s.Metric = SanitizeMetricName(s.Metric)
+			}
+			if rep.Filter != nil {
+				if s = rep.Filter(s); s == nil {
+					continue
+				}
+			}
+			dst = append(dst, s)
+		}
+	}
+	return dst
+}
+
+// marshallable filters out series which cannot be marshalled in
+// `BestEffort` mode, or which exceed `MaxSeriesBytes`
+func (rep *MetricReporter) marshallable(series []*Series) []*Series {
+	if !rep.BestEffort && rep.MaxSeriesBytes < 1 {
+		return series
 	}
 
+	valid := series[:0]
 	for _, s := range series {
-		s.Tags = append(s.Tags, rep.tags...)
-		s.Host = rep.client.Host
+		data, err := json.Marshal(s)
+		if err != nil && rep.BestEffort {
+			atomic.AddInt64(&rep.poisoned, 1)
+			log.Printf("Datadog series %s dropped: %s", s.Metric, err.Error())
+			continue
+		}
+		if rep.MaxSeriesBytes > 0 && len(data) > rep.MaxSeriesBytes {
+			atomic.AddInt64(&rep.oversized, 1)
+			log.Printf("Datadog series %s dropped: %d bytes exceed limit of %d", s.Metric, len(data), rep.MaxSeriesBytes)
+			continue
+		}
+		valid = append(valid, s)
 	}
+	return valid
+}
 
-	return series
+// retain stores series in the bounded list of recent reports
+func (rep *MetricReporter) retain(series []*Series) {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	if rep.KeepReports < 1 {
+		rep.recent = nil
+		return
+	}
+	rep.recent = append(rep.recent, series)
+	if n := len(rep.recent) - rep.KeepReports; n > 0 {
+		rep.recent = append(rep.recent[:0:0], rep.recent[n:]...)
+	}
+}
+
+// sortSeries sorts series by name, then tags
+func sortSeries(series []*Series) {
+	sort.SliceStable(series, func(i, j int) bool {
+		a, b := series[i], series[j]
+		if a.Metric != b.Metric {
+			return a.Metric < b.Metric
+		}
+		for k := 0; k < len(a.Tags) && k < len(b.Tags); k++ {
+			if a.Tags[k] != b.Tags[k] {
+				return a.Tags[k] < b.Tags[k]
+			}
+		}
+		return len(a.Tags) < len(b.Tags)
+	})
+}
+
+// safeFlush flushes m, recovering from and logging panics, so a single
+// faulty metric cannot stop the reporter
+func safeFlush(m Metric, now int64) (series []*Series) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Datadog metric %s panicked on flush: %v", m.Name(), r)
+			series = nil
+		}
+	}()
+	return m.Flush(now)
+}
+
+// withinWindow removes points with timestamps outside of the accepted
+// window from s and reports whether any points remain
+func (rep *MetricReporter) withinWindow(s *Series, now int64) bool {
+	if rep.MaxPointAge <= 0 && rep.MaxPointLead <= 0 {
+		return true
+	}
+
+	min, max := int64(math.MinInt64), int64(math.MaxInt64)
+	if rep.MaxPointAge > 0 {
+		min = now - int64(rep.MaxPointAge/time.Second)
+	}
+	if rep.MaxPointLead > 0 {
+		max = now + int64(rep.MaxPointLead/time.Second)
+	}
+
+	points := s.Points[:0:0]
+	for _, p := range s.Points {
+		if t, ok := toFloat64(p[0]); ok && (t < float64(min) || t > float64(max)) {
+			atomic.AddInt64(&rep.rejected, 1)
+			continue
+		}
+		points = append(points, p)
+	}
+	s.Points = points
+	return len(points) != 0
+}
+
+// withAliases returns s, followed by a copy for each matching alias
+func withAliases(s *Series, aliases map[string]string) []*Series {
+	all := []*Series{s}
+	for prefix, alias := range aliases {
+		if strings.HasPrefix(s.Metric, prefix) {
+			dup := *s
+			dup.Metric = alias + s.Metric[len(prefix):]
+			dup.Tags = joinTags(s.Tags)
+			dup.Points = append([][2]interface{}(nil), s.Points...)
+			all = append(all, &dup)
+		}
+	}
+	return all
+}
+
+// admit checks if a metric with the given id may be added to the registry,
+// counts the drop otherwise. The lock must be held.
+func (rep *MetricReporter) admit(id string) bool {
+	if rep.MaxMetrics < 1 || len(rep.registry) < rep.MaxMetrics {
+		return true
+	}
+	if _, ok := rep.registry[id]; ok {
+		return true
+	}
+
+	atomic.AddInt64(&rep.dropped, 1)
+	rep.dropWarning.Do(func() {
+		log.Printf("Datadog registry limit of %d metrics reached, dropping new metrics", rep.MaxMetrics)
+	})
+	return false
+}
+
+// release stops the arbiter ticking a metric which is no longer, or was
+// never, registered
+func release(m Metric) {
+	switch v := m.(type) {
+	case *Timer:
+		arbiter.remove(v.Meter)
+	case *Operation:
+		arbiter.remove(v.meter)
+		arbiter.remove(v.timer.Meter)
+	case tickableMetric:
+		arbiter.remove(v)
+	}
+}
+
+func (rep *MetricReporter) registeredTypeTags() map[reflect.Type][]string {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	tt := make(map[reflect.Type][]string, len(rep.typeTags))
+	for k, v := range rep.typeTags {
+		tt[k] = v
+	}
+	return tt
 }
 
 func (rep *MetricReporter) registered() []Metric {
@@ -0,0 +1,477 @@
+package datadog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingPoster records submitted reports. If block is set, submissions
+// wait until it is closed or the context is cancelled.
+type recordingPoster struct {
+	block   chan struct{}
+	err     error
+	lock    sync.Mutex
+	reports [][]*Series
+}
+
+func (p *recordingPoster) hostname() string { return "host" }
+
+func (p *recordingPoster) PostSeriesContext(ctx context.Context, series []*Series) error {
+	if p.block != nil {
+		select {
+		case <-p.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.reports = append(p.reports, series)
+	return p.err
+}
+
+// values returns the first point value of each report
+func (p *recordingPoster) values() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var values []string
+	for _, series := range p.reports {
+		if len(series) != 0 {
+			values = append(values, fmt.Sprint(series[0].Points[0][1]))
+		}
+	}
+	return values
+}
+
+func isTicked(m tickableMetric) bool {
+	arbiter.Lock()
+	defer arbiter.Unlock()
+
+	for _, mm := range arbiter.metrics {
+		if mm == m {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFetchMaxMetrics(t *testing.T) {
+	rep := NewReporter(nil)
+	rep.MaxMetrics = 1
+
+	var created []*Meter
+	fetch := func(name string) *Meter {
+		return rep.Fetch(func() Metric {
+			m := NewMeter(name)
+			created = append(created, m)
+			return m
+		}, name).(*Meter)
+	}
+
+	kept := fetch("kept")
+	fetch("dropped.a")
+	fetch("dropped.a")
+	fetch("dropped.b")
+
+	if n := rep.Dropped(); n != 3 {
+		t.Errorf("expected 3 dropped metrics, got %d", n)
+	}
+	if fetch("kept") != kept {
+		t.Error("expected registered metric to be fetched")
+	}
+	if !isTicked(kept) {
+		t.Error("expected registered meter to be ticked")
+	}
+	for _, m := range created[1:] {
+		if isTicked(m) {
+			t.Errorf("expected dropped meter %s to be released", m.Name())
+		}
+	}
+	rep.Clear()
+}
+
+// sharedTagsMetric returns series sharing its tags slice, which has spare
+// capacity, so appending to series tags would leak into the metric
+type sharedTagsMetric struct {
+	BaseMetric
+}
+
+func (m *sharedTagsMetric) Flush(now int64) []*Series {
+	return []*Series{NewSeries(m.name, now, 1, m.tags, MT_GAUGE)}
+}
+
+func TestRegisterReleasesMetrics(t *testing.T) {
+	rep := NewReporter(nil)
+	rep.MaxMetrics = 1
+	defer rep.Clear()
+
+	first, second := NewMeter("kept"), NewMeter("kept")
+	rep.Register(first)
+	rep.Register(second)
+	if isTicked(first) || !isTicked(second) {
+		t.Error("expected replaced meter to be released")
+	}
+
+	dropped := NewMeter("dropped")
+	rep.Register(dropped)
+	if n := rep.RegisterAll(NewTimer("dropped.timer", time.Millisecond)); n != 0 {
+		t.Errorf("expected no metrics to be registered, got %d", n)
+	}
+	if isTicked(dropped) {
+		t.Error("expected dropped meter to be released")
+	}
+	if n := rep.Dropped(); n != 2 {
+		t.Errorf("expected 2 dropped metrics, got %d", n)
+	}
+}
+
+func TestSeriesTagsDoNotAccumulate(t *testing.T) {
+	tags := make([]string, 1, 8)
+	tags[0] = "metric:tag"
+	m := &sharedTagsMetric{BaseMetric{name: "shared", tags: tags}}
+
+	rep := NewReporter(New("host", "key"), "reporter:tag")
+	rep.Register(m)
+
+	for i := 0; i < 3; i++ {
+		series := rep.Series()
+		if len(series) != 1 {
+			t.Fatalf("expected 1 series, got %d", len(series))
+		}
+		if got := series[0].Tags; len(got) != 2 || got[0] != "metric:tag" || got[1] != "reporter:tag" {
+			t.Errorf("flush %d: unexpected tags %q", i, got)
+		}
+		if got := m.Tags(); len(got) != 1 || got[0] != "metric:tag" {
+			t.Errorf("flush %d: metric tags changed to %q", i, got)
+		}
+	}
+}
+
+type panickingMetric struct {
+	BaseMetric
+}
+
+func (m *panickingMetric) Flush(int64) []*Series { panic("broken metric") }
+
+func TestSeriesRecoversFromPanic(t *testing.T) {
+	rep := NewReporter(New("host", "key"))
+	rep.Register(&panickingMetric{BaseMetric{name: "broken"}})
+	g := RegisterGauge(rep, "ok")
+	g.Update(1)
+
+	series := rep.Series()
+	if len(series) != 1 || series[0].Metric != "ok.value" {
+		t.Fatalf("expected only the healthy gauge, got %d series", len(series))
+	}
+}
+
+func TestTaggedCopiesConfig(t *testing.T) {
+	rep := NewReporter(nil)
+	defer rep.Clear()
+
+	c := NewCounter("counter", "a:b")
+	c.AsRate, c.SampleRate = true, 0.5
+	c.SetHost("other")
+	c.SetMetricType(MT_GAUGE)
+	tc := rep.Tagged(c, "c:d").(*Counter)
+	if !tc.AsRate || tc.SampleRate != 0.5 || tc.Host() != "other" || tc.MetricType() != MT_GAUGE {
+		t.Errorf("expected counter config to be copied, got %+v", tc)
+	}
+	if tags := tc.Tags(); len(tags) != 2 || tags[1] != "c:d" {
+		t.Errorf("unexpected tags %q", tags)
+	}
+
+	g := NewGaugeF("gauge")
+	g.OnlyChanged = true
+	g.SetHostless(true)
+	if tg := rep.Tagged(g, "c:d").(*GaugeF); !tg.OnlyChanged || !tg.Hostless() {
+		t.Error("expected gauge config to be copied")
+	}
+
+	h := NewHistogram("histogram")
+	h.PercentileNamer, h.ReportSampleSize = AgentPercentileName, true
+	if th := rep.Tagged(h, "c:d").(*Histogram); th.PercentileNamer == nil || !th.ReportSampleSize {
+		t.Error("expected histogram config to be copied")
+	}
+
+	o := NewOperation("operation", time.Millisecond)
+	defer release(o)
+	o.Timer().PercentileNamer = AgentPercentileName
+	to := rep.Tagged(o, "c:d").(*Operation)
+	if to.Timer().PercentileNamer == nil || to.Timer().Name() != "operation.latency" {
+		t.Error("expected operation timer config to be copied")
+	}
+
+	d := NewDistribution("distribution")
+	d.Buckets = []float64{1, 2}
+	if td := rep.Tagged(d, "c:d").(*Distribution); len(td.Buckets) != 2 {
+		t.Error("expected distribution buckets to be copied")
+	}
+}
+
+func TestTaggedSupportsAllTypes(t *testing.T) {
+	rep := NewReporter(nil)
+	defer rep.Clear()
+
+	metrics := []Metric{
+		NewCounter("a"), NewFlashCounter("a"), NewMonotonicCounter("a"), NewCounterU("a"),
+		NewDecayingCounter("a", time.Minute), NewGauge("a"), NewFlashGauge("a"), NewPercentGauge("a"),
+		NewGaugeU("a"), NewGaugeF("a"), NewGaugeStats("a"), NewMeter("a"), NewHistogram("a"),
+		NewSketch("a", 0.01), NewTimer("a", time.Millisecond), NewOperation("a", time.Millisecond),
+		NewDistribution("a"),
+	}
+	for i, m := range metrics {
+		defer release(m)
+		if tm := rep.Tagged(m, "i:"+strconv.Itoa(i)); tm == nil {
+			t.Errorf("expected %T to be supported", m)
+		} else if reflect.TypeOf(tm) != reflect.TypeOf(m) || tm == m {
+			t.Errorf("expected a new %T, got %T", m, tm)
+		}
+	}
+}
+
+func TestSeriesKeepsDistributionType(t *testing.T) {
+	rep := NewReporter(New("host", "key"))
+	h := RegisterHistogram(rep, "hist")
+	h.ReportDistribution = true
+	h.SetMetricType(MT_GAUGE)
+	h.Update(1)
+
+	found := false
+	for _, s := range rep.Series() {
+		if s.Metric == "hist" {
+			found = true
+			if s.Type != MT_DISTRIBUTION {
+				t.Errorf("expected distribution series to keep its type, got %s", s.Type)
+			}
+		}
+	}
+	if !found {
+		t.Error("expected a distribution series")
+	}
+}
+
+func TestWithAliasesCopiesPoints(t *testing.T) {
+	s := NewSeries("old.name", 1, 2.0, nil, MT_GAUGE)
+	all := withAliases(s, map[string]string{"old.": "new."})
+	if len(all) != 2 || all[1].Metric != "new.name" {
+		t.Fatalf("expected an aliased copy, got %d series", len(all))
+	}
+
+	all[1].Points[0][1] = 3.0
+	if s.Points[0][1] != 2.0 {
+		t.Errorf("expected alias points to be copied, original changed to %v", s.Points[0][1])
+	}
+}
+
+func TestReportQueueDropsOldest(t *testing.T) {
+	p := &recordingPoster{block: make(chan struct{})}
+	rep := newReporter(p)
+	rep.QueueDepth = 2
+	g := RegisterGauge(rep, "g")
+
+	g.Update(1)
+	rep.Report()
+	// wait for the sender to pick up the first report
+	for len(rep.queue) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := int64(2); i <= 4; i++ {
+		g.Update(i)
+		if err := rep.Report(); err != nil {
+			t.Fatalf("expected queued reports to succeed, got %v", err)
+		}
+	}
+	if n := rep.QueueDropped(); n != 1 {
+		t.Errorf("expected 1 dropped report, got %d", n)
+	}
+
+	close(p.block)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := rep.Drain(ctx); err != nil {
+		t.Fatalf("expected queue to drain, got %v", err)
+	}
+	if v := p.values(); !reflect.DeepEqual(v, []string{"1", "3", "4"}) {
+		t.Errorf("expected the oldest queued report to be dropped, got %v", v)
+	}
+}
+
+// unmarshallableMetric returns a series with a point which cannot be encoded
+type unmarshallableMetric struct {
+	BaseMetric
+}
+
+func (m *unmarshallableMetric) Flush(now int64) []*Series {
+	return []*Series{NewSeries(m.name, now, make(chan int), m.tags, MT_GAUGE)}
+}
+
+func TestReportBestEffort(t *testing.T) {
+	p := &recordingPoster{}
+	rep := newReporter(p)
+	rep.BestEffort = true
+	rep.Register(&unmarshallableMetric{BaseMetric{name: "poison"}})
+	RegisterGauge(rep, "ok").Update(1)
+
+	if err := rep.Report(); err != nil {
+		t.Fatalf("expected report to succeed, got %v", err)
+	}
+	if n := rep.MarshalErrors(); n != 1 {
+		t.Errorf("expected 1 marshal error, got %d", n)
+	}
+	if len(p.reports) != 1 || len(p.reports[0]) != 1 || p.reports[0][0].Metric != "ok.value" {
+		t.Errorf("expected only the healthy gauge to be submitted, got %v", p.reports)
+	}
+}
+
+func TestReportTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	rep := NewReporter(New("host", "key", WithEndpoint(srv.URL)))
+	rep.ReportTimeout = 20 * time.Millisecond
+	RegisterGauge(rep, "g").Update(1)
+
+	start := time.Now()
+	err := rep.reportWithTimeout()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the report to be abandoned, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected the report to be abandoned after the timeout, took %v", d)
+	}
+}
+
+func TestRecentReports(t *testing.T) {
+	rep := newReporter(&recordingPoster{})
+	g := RegisterGauge(rep, "g")
+
+	g.Update(1)
+	rep.Report()
+	if r := rep.RecentReports(); r != nil {
+		t.Errorf("expected no retained reports by default, got %d", len(r))
+	}
+
+	rep.KeepReports = 2
+	for i := int64(2); i <= 4; i++ {
+		g.Update(i)
+		rep.Report()
+	}
+	var values []string
+	for _, series := range rep.RecentReports() {
+		values = append(values, fmt.Sprint(series[0].Points[0][1]))
+	}
+	if !reflect.DeepEqual(values, []string{"3", "4"}) {
+		t.Errorf("expected the last 2 reports, oldest first, got %v", values)
+	}
+}
+
+// backfillMetric returns a series with a point at each offset from now
+type backfillMetric struct {
+	BaseMetric
+	offsets []time.Duration
+}
+
+func (m *backfillMetric) Flush(now int64) []*Series {
+	s := &Series{Metric: m.name, Type: MT_GAUGE}
+	for _, d := range m.offsets {
+		s.Points = append(s.Points, [2]interface{}{float64(now + int64(d/time.Second)), 1.0})
+	}
+	return []*Series{s}
+}
+
+func TestSeriesRejectsPointsOutsideWindow(t *testing.T) {
+	rep := newReporter(&recordingPoster{})
+	rep.Now = func() time.Time { return time.Unix(100000, 0) }
+	rep.MaxPointAge = 4 * time.Hour
+	rep.MaxPointLead = 10 * time.Minute
+	rep.Register(&backfillMetric{BaseMetric{name: "mixed"}, []time.Duration{-5 * time.Hour, -time.Hour, 0, 20 * time.Minute}})
+	rep.Register(&backfillMetric{BaseMetric{name: "stale"}, []time.Duration{-24 * time.Hour}})
+
+	series := rep.Series()
+	if len(series) != 1 || series[0].Metric != "mixed" {
+		t.Fatalf("expected only the series with points in the window, got %d series", len(series))
+	}
+	if n := len(series[0].Points); n != 2 {
+		t.Errorf("expected 2 points within the window, got %d", n)
+	}
+	if n := rep.RejectedPoints(); n != 3 {
+		t.Errorf("expected 3 rejected points, got %d", n)
+	}
+}
+
+func TestReportMetric(t *testing.T) {
+	p := &recordingPoster{}
+	rep := newReporter(p)
+	RegisterCounter(rep, "jobs", "job:a").Inc(1)
+	RegisterCounter(rep, "other").Inc(1)
+
+	if err := rep.ReportMetric(context.Background(), "jobs", "job:a"); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.reports) != 1 || len(p.reports[0]) != 1 || p.reports[0][0].Metric != "jobs.count" {
+		t.Errorf("expected only the requested metric to be submitted, got %v", p.reports)
+	}
+
+	if err := rep.ReportMetric(context.Background(), "jobs", "job:b"); err == nil {
+		t.Error("expected an error for an unregistered metric")
+	}
+	if len(p.reports) != 1 {
+		t.Errorf("expected nothing to be submitted for an unregistered metric, got %d reports", len(p.reports))
+	}
+}
+
+func TestReportDropsOversizedSeries(t *testing.T) {
+	p := &recordingPoster{}
+	rep := newReporter(p)
+	rep.MaxSeriesBytes = 512
+	tags := make([]string, 100)
+	for i := range tags {
+		tags[i] = "tag:" + strconv.Itoa(i)
+	}
+	RegisterGauge(rep, "huge", tags...).Update(1)
+	RegisterGauge(rep, "small").Update(1)
+
+	if err := rep.Report(); err != nil {
+		t.Fatal(err)
+	}
+	if n := rep.OversizedSeries(); n != 1 {
+		t.Errorf("expected 1 oversized series, got %d", n)
+	}
+	if len(p.reports) != 1 || len(p.reports[0]) != 1 || p.reports[0][0].Metric != "small.value" {
+		t.Errorf("expected only the small series to be submitted, got %v", p.reports)
+	}
+}
+
+func TestFetchAsTypeMismatch(t *testing.T) {
+	rep := NewReporter(nil)
+	defer rep.Clear()
+	RegisterCounter(rep, "jobs", "job:a")
+
+	m, err := rep.FetchAs((*Counter)(nil), func() Metric { return NewCounter("jobs", "job:a") }, "jobs", "job:a")
+	if err != nil || m == nil {
+		t.Errorf("expected the registered counter, got %v", err)
+	}
+
+	m, err = rep.FetchAs((*Timer)(nil), func() Metric { return NewTimer("jobs", time.Millisecond, "job:a") }, "jobs", "job:a")
+	if err == nil || m != nil {
+		t.Errorf("expected a type mismatch error, got %T", m)
+	}
+}
@@ -7,16 +7,74 @@ import (
 	"container/heap"
 	"math"
 	"math/rand"
+	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const rescaleThreshold = time.Hour
+const (
+	rescaleThreshold     = time.Hour
+	defaultReservoirSize = 1028
+)
+
+// defaultSampleFactory creates samples for NewHistogram and NewTimer
+var defaultSampleFactory = NewDefaultSample
+
+// SetDefaultSampleFactory replaces the factory of samples used by NewHistogram
+// and NewTimer and their Fetch and Register variants, e.g. to use larger
+// reservoirs for an entire service. It is not safe for concurrent use and
+// must be called before any metrics are created. Pass nil to restore
+// NewDefaultSample.
+func SetDefaultSampleFactory(fn func() Sample) {
+	if fn == nil {
+		fn = NewDefaultSample
+	}
+	defaultSampleFactory = fn
+}
 
 // NewDefaultSample is a default constructor using an exponentially-decaying
 // sample with the same reservoir size and alpha as UNIX load averages.
-func NewDefaultSample() Sample { return NewExpDecaySample(1028, 0.015) }
+func NewDefaultSample() Sample { return NewDefaultSampleWithSize(defaultReservoirSize) }
+
+// NewDefaultSampleWithSize is like NewDefaultSample, but with a custom
+// reservoir size. Each reserved value occupies 16 bytes. Larger reservoirs
+// yield tighter percentiles, especially in the tail: a reservoir of 1028
+// holds only ~10 values above the 99th percentile, so p99 is noisy, while
+// p50 is accurate with a few hundred values. Sizes below 1 are raised to 1.
+func NewDefaultSampleWithSize(size int) Sample {
+	if size < 1 {
+		size = 1
+	}
+	return NewExpDecaySample(size, 0.015)
+}
+
+// NewDefaultSampleForRate is like NewDefaultSample, but picks the reservoir
+// size from the expected number of updates per second. The reservoir is sized
+// to hold roughly a minute of traffic, between 128 and 8192 values.
+func NewDefaultSampleForRate(perSecond float64) Sample {
+	size := int(perSecond * 60)
+	if size < 128 {
+		size = 128
+	} else if size > 8192 {
+		size = 8192
+	}
+	return NewDefaultSampleWithSize(size)
+}
+
+// PercentileMethod determines how percentiles are computed from a snapshot
+type PercentileMethod int
+
+const (
+	// PercentileLinear interpolates linearly between the two closest ranks,
+	// using the (N+1)p position. This is the default.
+	PercentileLinear PercentileMethod = iota
+	// PercentileNearestRank returns the smallest value for which at least p
+	// of all values are less or equal, without interpolation. This matches
+	// the behaviour of the Datadog agent's histogram aggregation most closely.
+	PercentileNearestRank
+)
 
 // Samples maintain a statistically-significant selection of values from
 // a stream.
@@ -89,31 +147,71 @@ func (s *SampleSnapshot) Percentile(p float64) float64 {
 }
 
 // Percentiles returns a slice of arbitrary percentiles of values at the time
-// the snapshot was taken.
+// the snapshot was taken, using linear interpolation.
 func (s *SampleSnapshot) Percentiles(ps []float64) []float64 {
+	return s.PercentilesWith(ps, PercentileLinear)
+}
+
+// PercentilesWith returns a slice of arbitrary percentiles of values at the
+// time the snapshot was taken, using the given method.
+func (s *SampleSnapshot) PercentilesWith(ps []float64, method PercentileMethod) []float64 {
 	scores := make([]float64, len(ps))
 
 	if size := len(s.values); size > 0 {
 		sort.Sort(s.values)
 		for i, p := range ps {
-			pos := p * float64(size+1)
-			if pos < 1.0 {
-				scores[i] = float64(s.values[0])
-			} else if pos >= float64(size) {
-				scores[i] = float64(s.values[size-1])
-			} else {
-				lower := float64(s.values[int(pos)-1])
-				upper := float64(s.values[int(pos)])
-				scores[i] = lower + (pos-math.Floor(pos))*(upper-lower)
+			switch method {
+			case PercentileNearestRank:
+				scores[i] = s.nearestRank(p, size)
+			default:
+				scores[i] = s.interpolate(p, size)
 			}
 		}
 	}
 	return scores
 }
 
+func (s *SampleSnapshot) interpolate(p float64, size int) float64 {
+	pos := p * float64(size+1)
+	if pos < 1.0 {
+		return float64(s.values[0])
+	} else if pos >= float64(size) {
+		return float64(s.values[size-1])
+	}
+	lower := float64(s.values[int(pos)-1])
+	upper := float64(s.values[int(pos)])
+	return lower + (pos-math.Floor(pos))*(upper-lower)
+}
+
+func (s *SampleSnapshot) nearestRank(p float64, size int) float64 {
+	rank := int(math.Ceil(p * float64(size)))
+	if rank < 1 {
+		rank = 1
+	} else if rank > size {
+		rank = size
+	}
+	return float64(s.values[rank-1])
+}
+
 // Size returns the size of the sample at the time the snapshot was taken.
 func (s *SampleSnapshot) Size() int { return len(s.values) }
 
+// ForEach calls fn for each value at the time the snapshot was taken, e.g.
+// to compute custom statistics in a single pass. Values are visited in
+// sorted order once percentiles were computed, in sample order otherwise.
+func (s *SampleSnapshot) ForEach(fn func(int64)) {
+	for _, v := range s.values {
+		fn(v)
+	}
+}
+
+// Values returns a copy of the values at the time the snapshot was taken.
+func (s *SampleSnapshot) Values() []int64 {
+	values := make([]int64, len(s.values))
+	copy(values, s.values)
+	return values
+}
+
 // StdDev returns the standard deviation of values at the time the snapshot was
 // taken.
 func (s *SampleSnapshot) StdDev() float64 { return math.Sqrt(s.Variance()) }
@@ -152,6 +250,7 @@ type ExpDecaySample struct {
 	count         int64
 	mutex         sync.Mutex
 	reservoirSize int
+	rescaleEvery  time.Duration
 	t0, t1        time.Time
 	values        expDecaySampleHeap
 }
@@ -159,13 +258,24 @@ type ExpDecaySample struct {
 // NewExpDecaySample constructs a new exponentially-decaying sample with the
 // given reservoir size and alpha.
 func NewExpDecaySample(reservoirSize int, alpha float64) *ExpDecaySample {
+	return NewExpDecaySampleWithRescale(reservoirSize, alpha, rescaleThreshold)
+}
+
+// NewExpDecaySampleWithRescale is like NewExpDecaySample, but with a custom
+// interval for rescaling priorities, instead of the default hour. Reservoir
+// sizes below 1 are raised to 1.
+func NewExpDecaySampleWithRescale(reservoirSize int, alpha float64, rescale time.Duration) *ExpDecaySample {
+	if reservoirSize < 1 {
+		reservoirSize = 1
+	}
 	s := &ExpDecaySample{
 		alpha:         alpha,
 		reservoirSize: reservoirSize,
-		t0:            time.Now(),
+		rescaleEvery:  rescale,
+		t0:            clock.Now(),
 		values:        make(expDecaySampleHeap, 0, reservoirSize),
 	}
-	s.t1 = time.Now().Add(rescaleThreshold)
+	s.t1 = s.t0.Add(rescale)
 	return s
 }
 
@@ -177,8 +287,8 @@ func (s *ExpDecaySample) Clear() {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	s.count = 0
-	s.t0 = time.Now()
-	s.t1 = s.t0.Add(rescaleThreshold)
+	s.t0 = clock.Now()
+	s.t1 = s.t0.Add(s.rescaleEvery)
 	s.values = make(expDecaySampleHeap, 0, s.reservoirSize)
 }
 
@@ -190,6 +300,12 @@ func (s *ExpDecaySample) Count() int64 {
 	return s.count
 }
 
+// ReservoirSize returns the configured reservoir size.
+func (s *ExpDecaySample) ReservoirSize() int { return s.reservoirSize }
+
+// Alpha returns the configured decay factor.
+func (s *ExpDecaySample) Alpha() float64 { return s.alpha }
+
 // Size returns the size of the sample, which is at most the reservoir size.
 func (s *ExpDecaySample) Size() int {
 	s.mutex.Lock()
@@ -199,7 +315,7 @@ func (s *ExpDecaySample) Size() int {
 
 // Update samples a new value.
 func (s *ExpDecaySample) Update(v int64) {
-	s.update(time.Now(), v)
+	s.update(clock.Now(), v)
 }
 
 // Values returns a copy of the values in the sample.
@@ -227,15 +343,29 @@ func (s *ExpDecaySample) update(t time.Time, v int64) {
 		v: v,
 	})
 	if t.After(s.t1) {
-		values := s.values
-		t0 := s.t0
-		s.values = make(expDecaySampleHeap, 0, s.reservoirSize)
-		s.t0 = t
-		s.t1 = s.t0.Add(rescaleThreshold)
-		for _, v := range values {
-			v.k = v.k * math.Exp(-s.alpha*float64(s.t0.Sub(t0)))
-			heap.Push(&s.values, v)
-		}
+		s.rescale(t)
+	}
+}
+
+// Rescale forces the forward-decay rescaling of priorities, which otherwise
+// happens automatically, by default once per hour. Useful for deterministic
+// testing.
+func (s *ExpDecaySample) Rescale() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rescale(clock.Now())
+}
+
+// rescale moves the landmark to t and rescales priorities. The lock must be held.
+func (s *ExpDecaySample) rescale(t time.Time) {
+	values := s.values
+	t0 := s.t0
+	s.values = make(expDecaySampleHeap, 0, s.reservoirSize)
+	s.t0 = t
+	s.t1 = s.t0.Add(s.rescaleEvery)
+	for _, v := range values {
+		v.k = v.k * math.Exp(-s.alpha*s.t0.Sub(t0).Seconds())
+		heap.Push(&s.values, v)
 	}
 }
 
@@ -250,8 +380,11 @@ type UniformSample struct {
 }
 
 // NewUniformSample constructs a new uniform sample with the given reservoir
-// size.
+// size. Reservoir sizes below 1 are raised to 1.
 func NewUniformSample(reservoirSize int) *UniformSample {
+	if reservoirSize < 1 {
+		reservoirSize = 1
+	}
 	return &UniformSample{
 		reservoirSize: reservoirSize,
 		values:        make([]int64, 0, reservoirSize),
@@ -321,6 +454,359 @@ func (s *FlashSample) Snapshot() *SampleSnapshot {
 	return snap
 }
 
+// WeightedSample is a weighted reservoir sample using Efraimidis and
+// Spirakis' Algorithm A-Res. Weights only decide which values are retained:
+// once the reservoir is full, a value of weight w replaces the lowest-keyed
+// entry if its random key u^(1/w) is higher, so heavy values are more likely
+// to survive. Retained values count once each towards percentiles and the
+// mean, and until the reservoir is full, weights have no effect at all.
+// Count reports the number of updates, not weights.
+//
+// <https://utopia.duth.gr/~pefraimi/research/data/2007EncOfAlg.pdf>
+type WeightedSample struct {
+	count         int64
+	mutex         sync.Mutex
+	reservoirSize int
+	values        expDecaySampleHeap
+}
+
+// NewWeightedSample constructs a new weighted sample with the given
+// reservoir size. Reservoir sizes below 1 are raised to 1.
+func NewWeightedSample(reservoirSize int) *WeightedSample {
+	if reservoirSize < 1 {
+		reservoirSize = 1
+	}
+	return &WeightedSample{
+		reservoirSize: reservoirSize,
+		values:        make(expDecaySampleHeap, 0, reservoirSize),
+	}
+}
+
+// Snapshot creates a read-only snapshot for statistical analysis
+func (s *WeightedSample) Snapshot() *SampleSnapshot { return NewSampleSnapshot(s.Count(), s.Values()) }
+
+// Clear clears all samples.
+func (s *WeightedSample) Clear() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.count = 0
+	s.values = make(expDecaySampleHeap, 0, s.reservoirSize)
+}
+
+// Count returns the number of updates recorded, which may exceed the
+// reservoir size.
+func (s *WeightedSample) Count() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.count
+}
+
+// Size returns the size of the sample, which is at most the reservoir size.
+func (s *WeightedSample) Size() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.values)
+}
+
+// Update samples a new value with a weight of one.
+func (s *WeightedSample) Update(v int64) { s.UpdateWeighted(v, 1) }
+
+// UpdateWeighted samples a new value with the given weight. Values with a
+// weight below one are ignored.
+func (s *WeightedSample) UpdateWeighted(v, weight int64) {
+	if weight < 1 {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.count++
+
+	item := expDecaySample{k: math.Pow(rand.Float64(), 1/float64(weight)), v: v}
+	if len(s.values) < s.reservoirSize {
+		heap.Push(&s.values, item)
+	} else if item.k > s.values[0].k {
+		heap.Pop(&s.values)
+		heap.Push(&s.values, item)
+	}
+}
+
+// Values returns a copy of the values in the sample.
+func (s *WeightedSample) Values() []int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	values := make([]int64, len(s.values))
+	for i, v := range s.values {
+		values[i] = v.v
+	}
+	return values
+}
+
+// SampledSample wraps another sample and records only every n-th update,
+// trading accuracy for throughput on hot paths. Counts are scaled by n, so
+// they remain approximately correct.
+type SampledSample struct {
+	Sample
+	n    int64
+	seen int64
+}
+
+// NewSampledSample wraps s, recording 1-in-n updates.
+func NewSampledSample(s Sample, n int) *SampledSample {
+	if n < 1 {
+		n = 1
+	}
+	return &SampledSample{Sample: s, n: int64(n)}
+}
+
+// Clear clears all samples.
+func (s *SampledSample) Clear() {
+	s.Sample.Clear()
+	atomic.StoreInt64(&s.seen, 0)
+}
+
+// Count returns the scaled number of samples recorded.
+func (s *SampledSample) Count() int64 { return s.Sample.Count() * s.n }
+
+// Snapshot creates a read-only snapshot with a scaled count
+func (s *SampledSample) Snapshot() *SampleSnapshot {
+	snap := s.Sample.Snapshot()
+	return NewSampleSnapshot(snap.count*s.n, snap.values)
+}
+
+// Update samples a new value, if it is the n-th.
+func (s *SampledSample) Update(v int64) {
+	if atomic.AddInt64(&s.seen, 1)%s.n == 0 {
+		s.Sample.Update(v)
+	}
+}
+
+// ShardedSample spreads updates across several sub-samples to reduce lock
+// contention under heavy parallel load, and merges them on snapshot. Each
+// shard is created by the given factory, so the merged reservoir holds up
+// to n times as many values as a single one.
+//
+// Updates pick a shard by a per-P hint, so goroutines running on the same
+// CPU mostly share a shard, while goroutines on different CPUs rarely
+// contend for one.
+type ShardedSample struct {
+	shards  []Sample
+	next    uint32
+	hints   sync.Pool // *shardHint
+	factory func() Sample
+}
+
+// shardHint is a shard index, cached per P by the pool of a ShardedSample
+type shardHint struct{ i int }
+
+// NewShardedSample constructs a new sharded sample with n shards, defaults
+// to one shard per CPU if n < 1.
+func NewShardedSample(n int, factory func() Sample) *ShardedSample {
+	if n < 1 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	shards := make([]Sample, n)
+	for i := range shards {
+		shards[i] = factory()
+	}
+
+	s := &ShardedSample{shards: shards, factory: factory}
+	s.hints.New = func() interface{} {
+		return &shardHint{int(atomic.AddUint32(&s.next, 1) % uint32(n))}
+	}
+	return s
+}
+
+// Clear clears all shards.
+func (s *ShardedSample) Clear() {
+	for _, shard := range s.shards {
+		shard.Clear()
+	}
+}
+
+// Count returns the number of samples recorded across all shards.
+func (s *ShardedSample) Count() int64 {
+	var count int64
+	for _, shard := range s.shards {
+		count += shard.Count()
+	}
+	return count
+}
+
+// Size returns the combined size of all shards.
+func (s *ShardedSample) Size() int {
+	var size int
+	for _, shard := range s.shards {
+		size += shard.Size()
+	}
+	return size
+}
+
+// Snapshot creates a read-only snapshot of all shards merged.
+func (s *ShardedSample) Snapshot() *SampleSnapshot {
+	var count int64
+	var values []int64
+	for _, shard := range s.shards {
+		snap := shard.Snapshot()
+		count += snap.count
+		values = append(values, snap.values...)
+	}
+	return NewSampleSnapshot(count, values)
+}
+
+// Update samples a new value in the shard of the current P.
+func (s *ShardedSample) Update(v int64) {
+	h := s.hints.Get().(*shardHint)
+	s.shards[h.i].Update(v)
+	s.hints.Put(h)
+}
+
+// Values returns a copy of the values in all shards.
+func (s *ShardedSample) Values() []int64 {
+	var values []int64
+	for _, shard := range s.shards {
+		values = append(values, shard.Values()...)
+	}
+	return values
+}
+
+// TimeWindowSample is implemented by samples which retain the time of each
+// value, so snapshots can be restricted to recent values
+type TimeWindowSample interface {
+	Sample
+	SnapshotSince(t time.Time) *SampleSnapshot
+}
+
+// SlidingTimeWindowSample retains all values observed within a trailing
+// time window, up to a maximum number of values. Once full, the oldest
+// values are evicted first.
+type SlidingTimeWindowSample struct {
+	mutex   sync.Mutex
+	window  time.Duration
+	maxSize int
+	count   int64
+	values  []timedValue
+}
+
+type timedValue struct {
+	t time.Time
+	v int64
+}
+
+// NewSlidingTimeWindowSample constructs a new sample retaining values of
+// the given window, up to maxSize values. A maxSize of zero or less retains
+// all values within the window, without limit.
+func NewSlidingTimeWindowSample(window time.Duration, maxSize int) *SlidingTimeWindowSample {
+	return &SlidingTimeWindowSample{window: window, maxSize: maxSize}
+}
+
+// Clear clears all samples.
+func (s *SlidingTimeWindowSample) Clear() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.count = 0
+	s.values = nil
+}
+
+// Count returns the number of samples recorded, which may exceed the
+// number of values retained.
+func (s *SlidingTimeWindowSample) Count() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.count
+}
+
+// Size returns the number of values within the window.
+func (s *SlidingTimeWindowSample) Size() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire(clock.Now())
+	return len(s.values)
+}
+
+// Snapshot creates a read-only snapshot of the values within the window.
+// Its count is the number of values retained within the window, unlike
+// Count.
+func (s *SlidingTimeWindowSample) Snapshot() *SampleSnapshot {
+	values := s.Values()
+	return NewSampleSnapshot(int64(len(values)), values)
+}
+
+// SnapshotSince creates a read-only snapshot of the values observed since t,
+// counting only those values.
+func (s *SlidingTimeWindowSample) SnapshotSince(t time.Time) *SampleSnapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.expire(clock.Now())
+	i := sort.Search(len(s.values), func(i int) bool { return !s.values[i].t.Before(t) })
+	values := make([]int64, 0, len(s.values)-i)
+	for _, tv := range s.values[i:] {
+		values = append(values, tv.v)
+	}
+	return NewSampleSnapshot(int64(len(values)), values)
+}
+
+// Update samples a new value.
+func (s *SlidingTimeWindowSample) Update(v int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := clock.Now()
+	s.count++
+	s.expire(now)
+	if s.maxSize > 0 && len(s.values) >= s.maxSize {
+		s.values = s.values[len(s.values)-s.maxSize+1:]
+	}
+	s.values = append(s.values, timedValue{t: now, v: v})
+}
+
+// Values returns a copy of the values within the window.
+func (s *SlidingTimeWindowSample) Values() []int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.expire(clock.Now())
+	values := make([]int64, len(s.values))
+	for i, tv := range s.values {
+		values[i] = tv.v
+	}
+	return values
+}
+
+// expire drops values older than the window. The mutex must be held.
+func (s *SlidingTimeWindowSample) expire(now time.Time) {
+	cutoff := now.Add(-s.window)
+	i := sort.Search(len(s.values), func(i int) bool { return s.values[i].t.After(cutoff) })
+	if i > 0 {
+		s.values = append(s.values[:0:0], s.values[i:]...)
+	}
+}
+
+// newSampleLike creates an empty sample of the same kind and configuration
+// as s, falling back to the default sample for unknown implementations
+func newSampleLike(s Sample) Sample {
+	switch v := s.(type) {
+	case *ExpDecaySample:
+		return NewExpDecaySampleWithRescale(v.reservoirSize, v.alpha, v.rescaleEvery)
+	case *FlashSample:
+		return NewFlashSample(v.reservoirSize)
+	case *UniformSample:
+		return NewUniformSample(v.reservoirSize)
+	case *WeightedSample:
+		return NewWeightedSample(v.reservoirSize)
+	case *SampledSample:
+		return NewSampledSample(newSampleLike(v.Sample), int(v.n))
+	case *ShardedSample:
+		return NewShardedSample(len(v.shards), v.factory)
+	case *DDSketchSample:
+		return NewDDSketchSampleWithBins(v.accuracy, v.maxBins)
+	case *SlidingTimeWindowSample:
+		return NewSlidingTimeWindowSample(v.window, v.maxSize)
+	}
+	return NewDefaultSample()
+}
+
 // expDecaySample represents an individual sample in a heap.
 type expDecaySample struct {
 	k float64
@@ -0,0 +1,166 @@
+package datadog
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// priorities returns the sample's priorities, keyed by value
+func priorities(s *ExpDecaySample) map[int64]float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ks := make(map[int64]float64, len(s.values))
+	for _, v := range s.values {
+		ks[v.v] = v.k
+	}
+	return ks
+}
+
+func TestExpDecaySampleRescale(t *testing.T) {
+	fc := NewFakeClock(time.Unix(1000, 0))
+	SetClock(fc)
+	defer SetClock(nil)
+
+	s := NewExpDecaySample(100, 0.015)
+	for i := int64(0); i < 10; i++ {
+		s.Update(i)
+		fc.Advance(time.Second)
+	}
+	before := priorities(s)
+
+	fc.Advance(10 * time.Minute)
+	s.Rescale()
+	after := priorities(s)
+
+	if len(after) != 10 {
+		t.Fatalf("expected 10 values after rescale, got %d", len(after))
+	}
+	for v, k := range after {
+		if k <= 0 {
+			t.Errorf("expected non-zero priority for %d, got %v", v, k)
+		}
+		for w, l := range after {
+			if (before[v] < before[w]) != (k < l) {
+				t.Errorf("expected order of %d and %d to be kept", v, w)
+			}
+		}
+	}
+}
+
+func TestExpDecaySampleRescaleThreshold(t *testing.T) {
+	fc := NewFakeClock(time.Unix(1000, 0))
+	SetClock(fc)
+	defer SetClock(nil)
+
+	s := NewExpDecaySampleWithRescale(100, 0.015, time.Minute)
+	s.Update(1)
+	fc.Advance(2 * time.Minute)
+	s.Update(2)
+
+	s.mutex.Lock()
+	t0 := s.t0
+	s.mutex.Unlock()
+	if !t0.Equal(fc.Now()) {
+		t.Errorf("expected landmark to move to %s, got %s", fc.Now(), t0)
+	}
+	for v, k := range priorities(s) {
+		if k <= 0 {
+			t.Errorf("expected non-zero priority for %d, got %v", v, k)
+		}
+	}
+	if n := s.Size(); n != 2 {
+		t.Errorf("expected 2 values, got %d", n)
+	}
+}
+
+func TestNewDefaultSampleWithSizeClamps(t *testing.T) {
+	for _, size := range []int{0, -5} {
+		s := NewDefaultSampleWithSize(size)
+		s.Update(1)
+		s.Update(2)
+		if n := s.Size(); n != 1 {
+			t.Errorf("size %d: expected reservoir of 1, got %d values", size, n)
+		}
+	}
+}
+
+func TestReservoirSamplesClamp(t *testing.T) {
+	for _, size := range []int{0, -5} {
+		for _, s := range []Sample{NewUniformSample(size), NewWeightedSample(size)} {
+			s.Update(1)
+			s.Update(2)
+			if n := s.Size(); n != 1 {
+				t.Errorf("%T size %d: expected reservoir of 1, got %d values", s, size, n)
+			}
+		}
+	}
+}
+
+func TestShardedSample(t *testing.T) {
+	s := NewShardedSample(4, func() Sample { return NewUniformSample(100) })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := int64(0); j < 50; j++ {
+				s.Update(j)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := s.Count(); n != 400 {
+		t.Errorf("expected 400 updates, got %d", n)
+	}
+	if n := s.Snapshot().Count(); n != 400 {
+		t.Errorf("expected merged snapshot of 400 updates, got %d", n)
+	}
+}
+
+// BenchmarkShardedSample updates an exponentially-decaying sample from all
+// CPUs. Throughput should scale with the number of shards, up to GOMAXPROCS,
+// e.g.
+//
+//	go test -run=NONE -bench=ShardedSample -cpu=1,4,16
+func BenchmarkShardedSample(b *testing.B) {
+	for _, n := range []int{1, 2, 4, 8, 16} {
+		b.Run("shards="+strconv.Itoa(n), func(b *testing.B) {
+			s := NewShardedSample(n, func() Sample { return NewExpDecaySample(defaultReservoirSize, 0.015) })
+			b.RunParallel(func(pb *testing.PB) {
+				for v := int64(0); pb.Next(); v++ {
+					s.Update(v)
+				}
+			})
+		})
+	}
+}
+
+func TestSlidingTimeWindowSample(t *testing.T) {
+	fc := NewFakeClock(time.Unix(1000, 0))
+	SetClock(fc)
+	defer SetClock(nil)
+
+	// unbounded, as maxSize is zero
+	h := NewCustomHistogram("window", NewSlidingTimeWindowSample(10*time.Minute, 0))
+	for i := int64(1); i <= 10; i++ {
+		h.Update(i * 100)
+		fc.Advance(time.Minute)
+	}
+	if p := h.PercentilesSince(3*time.Minute+time.Second, []float64{0, 1}); p[0] != 800 || p[1] != 1000 {
+		t.Errorf("expected percentiles of the last 3 minutes, got %v", p)
+	}
+
+	fc.Advance(5 * time.Minute)
+	s := h.sample
+	if snap := s.Snapshot(); snap.Count() != 4 || len(snap.Values()) != 4 {
+		t.Errorf("expected 4 values within the window, got a count of %d", snap.Count())
+	}
+	if n := s.Count(); n != 10 {
+		t.Errorf("expected 10 values recorded, got %d", n)
+	}
+}
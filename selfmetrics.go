@@ -0,0 +1,73 @@
+package datadog
+
+import (
+	"net/http"
+	"time"
+)
+
+// clientMetrics instrument a client's own requests
+type clientMetrics struct {
+	payload  *Histogram
+	latency  *Timer
+	statuses [numStatusClasses]*Counter
+}
+
+// Status classes of client responses
+const (
+	status2xx = iota
+	status3xx
+	status4xx
+	status5xx
+	statusError
+	numStatusClasses
+)
+
+var statusClassTags = [numStatusClasses]string{
+	"status_class:2xx", "status_class:3xx", "status_class:4xx", "status_class:5xx", "status_class:error",
+}
+
+// Instrument registers metrics about the client's own requests with rep:
+//
+//   - `datadog.client.payload`, a histogram of request body sizes in bytes
+//   - `datadog.client.request`, a timer of request latency in milliseconds
+//   - `datadog.client.responses`, counters tagged with the `status_class`,
+//     e.g. `status_class:5xx`, or `status_class:error` for network errors
+//
+// Each attempt of a retried request is recorded.
+func (c *Client) Instrument(rep *MetricReporter) {
+	m := &clientMetrics{
+		payload: FetchHistogram(rep, "datadog.client.payload"),
+		latency: FetchTimer(rep, "datadog.client.request", time.Millisecond),
+	}
+	for i, tag := range statusClassTags {
+		m.statuses[i] = FetchCounter(rep, "datadog.client.responses", tag)
+	}
+	c.metrics.Store(m)
+}
+
+// record records a request attempt, if the client is instrumented
+func (c *Client) record(req *http.Request, resp *http.Response, start time.Time) {
+	m, ok := c.metrics.Load().(*clientMetrics)
+	if !ok {
+		return
+	}
+
+	m.latency.UpdateSince(start)
+	if req.ContentLength > 0 {
+		m.payload.Update(req.ContentLength)
+	}
+
+	class := statusError
+	if resp != nil {
+		class = statusClass(resp.StatusCode)
+	}
+	m.statuses[class].Inc(1)
+}
+
+// statusClass returns the status class of an HTTP status code
+func statusClass(code int) int {
+	if code < 200 || code > 599 {
+		return statusError
+	}
+	return code/100 - 2
+}
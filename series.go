@@ -1,5 +1,14 @@
 package datadog
 
+import (
+	"encoding/json"
+	"math"
+	"strings"
+)
+
+// MaxMetricNameLength is the maximum length of a metric name accepted by Datadog
+const MaxMetricNameLength = 200
+
 type seriesMessage struct {
 	Series []*Series `json:"series,omitempty"`
 }
@@ -10,9 +19,13 @@ type Series struct {
 	Type   string           `json:"type"`
 	Host   string           `json:"host,omitempty"`
 	Tags   []string         `json:"tags,omitempty"`
+
+	// Interval in seconds, required for MT_RATE series
+	Interval int64 `json:"interval,omitempty"`
 }
 
-// NewSeries builds a series
+// NewSeries builds a series. Unsigned values are encoded exactly, so pass
+// large uint64 values as they are, rather than converting them to int64.
 func NewSeries(name string, t int64, v interface{}, tags []string, mt string) *Series {
 	return &Series{
 		Metric: name,
@@ -21,3 +34,189 @@ func NewSeries(name string, t int64, v interface{}, tags []string, mt string) *S
 		Tags:   tags,
 	}
 }
+
+// NewTypedSeries is like NewSeries, but with a typed MetricType. Rate series
+// require an interval, use NewRateSeries instead.
+func NewTypedSeries(name string, t int64, v interface{}, tags []string, mt MetricType) *Series {
+	return NewSeries(name, t, v, tags, mt.String())
+}
+
+// NewRateSeries builds a MT_RATE series with a per-second value over the
+// given interval in seconds, at least 1.
+func NewRateSeries(name string, t int64, perSecond float64, interval int64, tags []string) *Series {
+	if interval < 1 {
+		interval = 1
+	}
+	s := NewSeries(name, t, perSecond, tags, MT_RATE)
+	s.Interval = interval
+	return s
+}
+
+// MarshalJSON implements json.Marshaler. Non-finite values, such as NaN or
+// Inf, cannot be encoded in JSON and are reported as zero, so a single bad
+// value cannot fail an entire batch. Non-finite distribution values are
+// omitted instead.
+func (s *Series) MarshalJSON() ([]byte, error) {
+	type plain Series
+
+	for i, p := range s.Points {
+		if isFinite(p[1]) {
+			continue
+		}
+
+		points := make([][2]interface{}, len(s.Points))
+		copy(points, s.Points)
+		for j := i; j < len(points); j++ {
+			points[j][1] = finite(points[j][1])
+		}
+
+		cp := plain(*s)
+		cp.Points = points
+		return json.Marshal(&cp)
+	}
+	return json.Marshal((*plain)(s))
+}
+
+func isFinite(v interface{}) bool {
+	switch f := v.(type) {
+	case float64:
+		return !math.IsNaN(f) && !math.IsInf(f, 0)
+	case float32:
+		return !math.IsNaN(float64(f)) && !math.IsInf(float64(f), 0)
+	case []float64:
+		for _, x := range f {
+			if !isFinite(x) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// finite returns v with non-finite values replaced by zero, or omitted from
+// distribution values
+func finite(v interface{}) interface{} {
+	if isFinite(v) {
+		return v
+	}
+	if vs, ok := v.([]float64); ok {
+		kept := make([]float64, 0, len(vs))
+		for _, x := range vs {
+			if isFinite(x) {
+				kept = append(kept, x)
+			}
+		}
+		return kept
+	}
+	return 0
+}
+
+// SeriesBuilder builds several consistently named and tagged series, e.g.
+// for the components of a metric's `Flush`
+type SeriesBuilder struct {
+	Name      string
+	Tags      []string
+	Host      string
+	Timestamp int64
+
+	series []*Series
+}
+
+// NewSeriesBuilder creates a new builder
+func NewSeriesBuilder(name string, t int64, tags []string) *SeriesBuilder {
+	return &SeriesBuilder{Name: name, Tags: tags, Timestamp: t}
+}
+
+// Counter adds a counter series, suffix is appended to the name
+func (b *SeriesBuilder) Counter(suffix string, v interface{}) *SeriesBuilder {
+	return b.add(suffix, v, MT_COUNTER)
+}
+
+// Gauge adds a gauge series, suffix is appended to the name
+func (b *SeriesBuilder) Gauge(suffix string, v interface{}) *SeriesBuilder {
+	return b.add(suffix, v, MT_GAUGE)
+}
+
+// percentiles adds gauge series for the default percentiles of snap, named
+// by namer and with values converted by fn
+func (b *SeriesBuilder) percentiles(snap *SampleSnapshot, namer func(float64) string, fn func(float64) float64) *SeriesBuilder {
+	if namer == nil {
+		namer = DefaultPercentileName
+	}
+	for i, v := range snap.Percentiles(defaultPercentiles) {
+		b.Gauge(namer(defaultPercentiles[i]), fn(v))
+	}
+	return b
+}
+
+// Series returns the built series
+func (b *SeriesBuilder) Series() []*Series { return b.series }
+
+func (b *SeriesBuilder) add(suffix string, v interface{}, mt string) *SeriesBuilder {
+	s := NewSeries(b.Name+suffix, b.Timestamp, v, b.Tags, mt)
+	s.Host = b.Host
+	b.series = append(b.series, s)
+	return b
+}
+
+// SanitizeMetricName converts name into a valid Datadog metric name. Datadog
+// requires names to start with an ASCII letter, to contain only ASCII
+// alphanumerics, underscores and periods, and to be at most 200 characters
+// long. Runs of other characters are collapsed into a single underscore and
+// leading or trailing separators are trimmed. Case is preserved, as metric
+// names are case-sensitive. The result is never empty: names without any
+// letter, e.g. "123", are prefixed with "metric_", and names without any
+// valid character, e.g. "___" or "", become "metric".
+func SanitizeMetricName(name string) string {
+	buf := make([]byte, 0, len(name))
+	invalid := false
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if isMetricNameChar(c) {
+			if invalid && len(buf) > 0 {
+				buf = append(buf, '_')
+			}
+			buf = append(buf, c)
+			invalid = false
+		} else {
+			invalid = true
+		}
+	}
+
+	sanitized := strings.TrimLeftFunc(string(buf), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	})
+	if sanitized == "" {
+		sanitized = strings.TrimRight("metric_"+strings.Trim(string(buf), "._"), "_")
+	}
+	if len(sanitized) > MaxMetricNameLength {
+		sanitized = sanitized[:MaxMetricNameLength]
+	}
+	return strings.TrimRight(sanitized, "._")
+}
+
+// SanitizeMetricNameLower is like SanitizeMetricName, but also converts the
+// name to lowercase, e.g. to normalise names from external sources which
+// differ only in case.
+func SanitizeMetricNameLower(name string) string {
+	return strings.ToLower(SanitizeMetricName(name))
+}
+
+func isMetricNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.'
+}
+
+// splitDistributions separates MT_DISTRIBUTION series from others
+func splitDistributions(series []*Series) (others, dists []*Series) {
+	for _, s := range series {
+		if s.Type == MT_DISTRIBUTION {
+			dists = append(dists, s)
+		} else {
+			others = append(others, s)
+		}
+	}
+	if len(dists) == 0 {
+		return series, nil
+	}
+	return others, dists
+}
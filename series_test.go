@@ -0,0 +1,57 @@
+package datadog
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestSeriesMarshalNonFinite(t *testing.T) {
+	for _, v := range []interface{}{math.NaN(), math.Inf(1), math.Inf(-1), float32(math.Inf(1))} {
+		s := NewSeries("bad", 1, v, []string{"a:b"}, MT_GAUGE)
+		data, err := json.Marshal(&seriesMessage{Series: []*Series{s, NewSeries("good", 2, 3, nil, MT_GAUGE)}})
+		if err != nil {
+			t.Fatalf("failed to marshal %v: %s", v, err)
+		}
+		if want := `{"series":[{"metric":"bad","points":[[1,0]],"type":"gauge","tags":["a:b"]},{"metric":"good","points":[[2,3]],"type":"gauge"}]}`; string(data) != want {
+			t.Errorf("expected %s, got %s", want, data)
+		}
+		if isFinite(s.Points[0][1]) {
+			t.Errorf("expected series to be left untouched")
+		}
+	}
+}
+
+func TestSanitizeMetricName(t *testing.T) {
+	cases := map[string]string{
+		"My Metric!!name": "My_Metric_name",
+		"  9abc.":         "abc",
+		"a..b":            "a..b",
+		"123":             "metric_123",
+		"_1.2_":           "metric_1.2",
+		"___":             "metric",
+		"":                "metric",
+	}
+	for in, want := range cases {
+		if got := SanitizeMetricName(in); got != want {
+			t.Errorf("SanitizeMetricName(%q): expected %q, got %q", in, want, got)
+		}
+	}
+	if got := SanitizeMetricNameLower("My Metric.Name"); got != "my_metric.name" {
+		t.Errorf("expected lowercase name, got %q", got)
+	}
+}
+
+func TestReporterLowercaseNames(t *testing.T) {
+	rep := NewReporter(New("host", "key"))
+	rep.SanitizeNames, rep.LowercaseNames = true, true
+	RegisterGauge(rep, "Queue Length").Update(1)
+
+	series := rep.Series()
+	if len(series) != 1 {
+		t.Fatalf("expected 1 series, got %d", len(series))
+	}
+	if name := series[0].Metric; name != "queue_length.value" {
+		t.Errorf("expected sanitized lowercase name, got %q", name)
+	}
+}
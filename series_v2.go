@@ -0,0 +1,97 @@
+package datadog
+
+// Metric types of the v2 series intake
+const (
+	MT_V2_UNSPECIFIED = iota
+	MT_V2_COUNT
+	MT_V2_RATE
+	MT_V2_GAUGE
+)
+
+type seriesV2Message struct {
+	Series []*SeriesV2 `json:"series"`
+}
+
+// SeriesV2 is a series in the schema of the v2 intake
+type SeriesV2 struct {
+	Metric    string            `json:"metric"`
+	Type      int               `json:"type"`
+	Points    []PointV2         `json:"points"`
+	Resources []ResourceV2      `json:"resources,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	Interval  int64             `json:"interval,omitempty"`
+	Metadata  *SeriesV2Metadata `json:"metadata,omitempty"`
+}
+
+// PointV2 is a single v2 data point
+type PointV2 struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// ResourceV2 is a resource, such as a host, associated with a v2 series
+type ResourceV2 struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// SeriesV2Metadata holds optional v2 series metadata
+type SeriesV2Metadata struct {
+	Origin map[string]interface{} `json:"origin,omitempty"`
+}
+
+// V2 converts the series to the v2 schema. The host is mapped to a "host"
+// resource and non-numeric values are reported as zero.
+func (s *Series) V2() *SeriesV2 {
+	v2 := &SeriesV2{
+		Metric:   s.Metric,
+		Type:     metricTypeV2(s.Type),
+		Points:   make([]PointV2, 0, len(s.Points)),
+		Tags:     s.Tags,
+		Interval: s.Interval,
+	}
+	for _, p := range s.Points {
+		ts, _ := toFloat64(p[0])
+		val, _ := toFloat64(p[1])
+		v2.Points = append(v2.Points, PointV2{Timestamp: int64(ts), Value: val})
+	}
+	if s.Host != "" {
+		v2.Resources = []ResourceV2{{Name: s.Host, Type: "host"}}
+	}
+	return v2
+}
+
+func metricTypeV2(mt string) int {
+	switch mt {
+	case MT_COUNTER, "count":
+		return MT_V2_COUNT
+	case MT_RATE:
+		return MT_V2_RATE
+	case MT_GAUGE:
+		return MT_V2_GAUGE
+	}
+	return MT_V2_UNSPECIFIED
+}
+
+// toFloat64 converts a numeric value to a float64
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,44 @@
+package datadog
+
+import "time"
+
+// Stopwatch measures the stages of a multi-step operation. Each lap is
+// recorded by a timer tagged with `stage:<name>`, the total by a timer
+// tagged with `stage:total`. Timers are fetched from the reporter, so
+// they are registered on first use.
+//
+// A stopwatch is not safe for concurrent use.
+type Stopwatch struct {
+	rep         *MetricReporter
+	name        string
+	unit        time.Duration
+	tags        []string
+	start, last time.Time
+}
+
+// NewStopwatch creates and starts a new stopwatch
+func NewStopwatch(rep *MetricReporter, name string, unit time.Duration, tags ...string) *Stopwatch {
+	now := clock.Now()
+	return &Stopwatch{rep: rep, name: name, unit: unit, tags: tags, start: now, last: now}
+}
+
+// Lap records the duration since the previous lap (or start) for the
+// given stage and returns it.
+func (s *Stopwatch) Lap(stage string) time.Duration {
+	now := clock.Now()
+	d := now.Sub(s.last)
+	s.last = now
+	s.record("stage:"+stage, d)
+	return d
+}
+
+// Stop records the total duration since start and returns it.
+func (s *Stopwatch) Stop() time.Duration {
+	d := clock.Now().Sub(s.start)
+	s.record("stage:total", d)
+	return d
+}
+
+func (s *Stopwatch) record(tag string, d time.Duration) {
+	FetchTimer(s.rep, s.name, s.unit, joinTags(s.tags, tag)...).Update(d)
+}
@@ -1,17 +1,46 @@
 package datadog
 
-import "time"
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Outcomes recorded by TimeContext
+const (
+	outcomeOK = iota
+	outcomeError
+	outcomeCanceled
+	outcomeTimeout
+	numOutcomes
+)
+
+var outcomeTags = [numOutcomes]string{"outcome:ok", "outcome:error", "outcome:canceled", "outcome:timeout"}
 
 // A standard timer
 type Timer struct {
 	*Meter
-	unit   float64
-	sample Sample
+	unit     float64
+	sample   Sample
+	outcomes [numOutcomes]int64
+	dropped  int64
+
+	// ReportSampleSize emits an additional `.samplesize` gauge with the
+	// number of values in the sample, to detect saturated reservoirs
+	ReportSampleSize bool
+
+	// PercentileNamer returns the series suffix for a percentile,
+	// defaults to `DefaultPercentileName`
+	PercentileNamer func(p float64) string
 }
 
-// NewCustomTimer creates a new timer
+// NewCustomTimer creates a new timer. Units smaller than a nanosecond
+// are treated as nanoseconds.
 func NewCustomTimer(name string, unit time.Duration, sample Sample, tags ...string) *Timer {
-	return &Timer{NewMeter(name, tags...), float64(unit), sample}
+	if unit < time.Nanosecond {
+		unit = time.Nanosecond
+	}
+	return &Timer{Meter: NewMeter(name, tags...), unit: float64(unit), sample: sample}
 }
 
 // FetchCustomTimer returns or registers a new one
@@ -26,9 +55,14 @@ func RegisterCustomTimer(rep *MetricReporter, name string, unit time.Duration, s
 	return m
 }
 
-// NewTimer creates a new timer with a default exponentially-decaying sample
+// NewTimer creates a new timer with a default sample, see SetDefaultSampleFactory
 func NewTimer(name string, unit time.Duration, tags ...string) *Timer {
-	return NewCustomTimer(name, unit, NewDefaultSample(), tags...)
+	return NewCustomTimer(name, unit, defaultSampleFactory(), tags...)
+}
+
+// NewTimerM is like NewTimer, but with tags converted by TagsFromMap
+func NewTimerM(name string, unit time.Duration, tags map[string]string) *Timer {
+	return NewTimer(name, unit, TagsFromMap(tags)...)
 }
 
 // FetchTimer returns or registers a new one
@@ -38,7 +72,37 @@ func FetchTimer(rep *MetricReporter, name string, unit time.Duration, tags ...st
 
 // RegisterTimer registers a meter
 func RegisterTimer(rep *MetricReporter, name string, unit time.Duration, tags ...string) *Timer {
-	return RegisterCustomTimer(rep, name, unit, NewDefaultSample(), tags...)
+	return RegisterCustomTimer(rep, name, unit, defaultSampleFactory(), tags...)
+}
+
+// NewFlashTimer creates a new timer with a flash sample. The sample is cleared
+// on every flush, so reported stats reflect only the last interval. Rates are
+// unaffected.
+func NewFlashTimer(name string, unit time.Duration, tags ...string) *Timer {
+	return NewCustomTimer(name, unit, NewFlashSample(defaultReservoirSize), tags...)
+}
+
+// FetchFlashTimer returns or registers a new one
+func FetchFlashTimer(rep *MetricReporter, name string, unit time.Duration, tags ...string) *Timer {
+	return rep.Fetch(func() Metric { return NewFlashTimer(name, unit, tags...) }, name, tags...).(*Timer)
+}
+
+// RegisterFlashTimer registers a flash timer
+func RegisterFlashTimer(rep *MetricReporter, name string, unit time.Duration, tags ...string) *Timer {
+	return RegisterCustomTimer(rep, name, unit, NewFlashSample(defaultReservoirSize), tags...)
+}
+
+func (t *Timer) withTags(name string, tags []string) Metric { return t.like(name, tags) }
+
+// like creates a timer with the configuration and a fresh sample of t
+func (t *Timer) like(name string, tags []string) *Timer {
+	return &Timer{
+		Meter:            t.Meter.like(name, tags),
+		unit:             t.unit,
+		sample:           newSampleLike(t.sample),
+		ReportSampleSize: t.ReportSampleSize,
+		PercentileNamer:  t.PercentileNamer,
+	}
 }
 
 // Clear clears the histogram and its sample.
@@ -47,34 +111,81 @@ func (t *Timer) Clear() { t.sample.Clear() }
 // Snapshot returns a read-only snapshot for statistical analysis
 func (t *Timer) Snapshot() *SampleSnapshot { return t.sample.Snapshot() }
 
-// Update records the duration of an event.
+// Update records the duration of an event. Negative durations are dropped
+// and counted, as they are indicative of a bug.
 func (t *Timer) Update(d time.Duration) {
+	if d < 0 {
+		atomic.AddInt64(&t.dropped, 1)
+		return
+	}
 	t.sample.Update(int64(d))
 	t.Mark(1)
 }
 
+// Dropped returns the number of negative durations which were dropped.
+func (t *Timer) Dropped() int64 {
+	return atomic.LoadInt64(&t.dropped)
+}
+
 // UpdateSince records the duration of an event that started at a time and ends now.
-func (t *Timer) UpdateSince(ts time.Time) { t.Update(time.Now().Sub(ts)) }
+func (t *Timer) UpdateSince(ts time.Time) { t.Update(clock.Now().Sub(ts)) }
+
+// TimeContext calls f and records its duration, whether it succeeds, fails
+// or the context is cancelled. The outcome is counted and flushed as a
+// `.outcome` counter series, tagged with `outcome:ok`, `outcome:error`,
+// `outcome:canceled` or `outcome:timeout`. Returns the error of f.
+func (t *Timer) TimeContext(ctx context.Context, f func(context.Context) error) (err error) {
+	start := clock.Now()
+	defer func() {
+		t.UpdateSince(start)
+
+		outcome := outcomeOK
+		if ctxErr := ctx.Err(); ctxErr == context.DeadlineExceeded {
+			outcome = outcomeTimeout
+		} else if ctxErr != nil {
+			outcome = outcomeCanceled
+		} else if err != nil {
+			outcome = outcomeError
+		}
+		atomic.AddInt64(&t.outcomes[outcome], 1)
+	}()
+	return f(ctx)
+}
 
 // Flush returns series
 func (t *Timer) Flush(now int64) []*Series {
+	t.untouch()
+	b := t.latency(t.rates(NewSeriesBuilder(t.name, now, t.tags)))
+
+	series := b.Series()
+	for i := range t.outcomes {
+		if n := atomic.LoadInt64(&t.outcomes[i]); n != 0 {
+			series = append(series, NewSeries(t.name+".outcome", now, n, joinTags(t.tags, outcomeTags[i]), MT_COUNTER))
+		}
+	}
+	return series
+}
+
+// latency adds the duration stats series to b
+func (t *Timer) latency(b *SeriesBuilder) *SeriesBuilder {
 	snap := t.Snapshot()
-	p := snap.Percentiles([]float64{0.5, 0.75, 0.95, 0.99})
-	return []*Series{
-		NewSeries(t.name+".rate", now, t.RateMean(), t.tags, MT_GAUGE),
-		NewSeries(t.name+".rate1", now, t.Rate1(), t.tags, MT_GAUGE),
-		NewSeries(t.name+".rate5", now, t.Rate5(), t.tags, MT_GAUGE),
-		NewSeries(t.name+".rate15", now, t.Rate15(), t.tags, MT_GAUGE),
-		NewSeries(t.name+".count", now, snap.Count(), t.tags, MT_COUNTER),
-		NewSeries(t.name+".min", now, t.norm(snap.Min()), t.tags, MT_GAUGE),
-		NewSeries(t.name+".max", now, t.norm(snap.Max()), t.tags, MT_GAUGE),
-		NewSeries(t.name+".mean", now, snap.Mean()/t.unit, t.tags, MT_GAUGE),
-		NewSeries(t.name+".stddev", now, snap.StdDev()/t.unit, t.tags, MT_GAUGE),
-		NewSeries(t.name+".median", now, p[0]/t.unit, t.tags, MT_GAUGE),
-		NewSeries(t.name+".percentile.75", now, p[1]/t.unit, t.tags, MT_GAUGE),
-		NewSeries(t.name+".percentile.95", now, p[2]/t.unit, t.tags, MT_GAUGE),
-		NewSeries(t.name+".percentile.99", now, p[3]/t.unit, t.tags, MT_GAUGE),
+	if t.ReportSampleSize {
+		b.Gauge(".samplesize", snap.Size())
 	}
+	return b.
+		Counter(".count", snap.Count()).
+		Gauge(".min", t.norm(snap.Min())).
+		Gauge(".max", t.norm(snap.Max())).
+		Gauge(".mean", snap.Mean()/t.unit).
+		Gauge(".stddev", snap.StdDev()/t.unit).
+		percentiles(snap, t.PercentileNamer, func(v float64) float64 { return v / t.unit })
 }
 
 func (t *Timer) norm(n int64) float64 { return float64(n) / t.unit }
+
+// SnapshotValues returns the current rates and duration stats, without
+// flushing. The count is the number of sampled durations.
+func (t *Timer) SnapshotValues() map[string]interface{} {
+	vals := t.Meter.snapshotValues(make(map[string]interface{}, 12))
+	return sampleValues(vals, t.sample, t.PercentileNamer, func(v float64) float64 { return v / t.unit })
+}
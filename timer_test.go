@@ -0,0 +1,73 @@
+package datadog
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func assertFinite(t *testing.T, series []*Series) {
+	t.Helper()
+	for _, s := range series {
+		for _, p := range s.Points {
+			if v, ok := p[1].(float64); ok && (math.IsNaN(v) || math.IsInf(v, 0)) {
+				t.Errorf("series %s has non-finite value %v", s.Metric, v)
+			}
+		}
+	}
+	if _, err := json.Marshal(series); err != nil {
+		t.Errorf("failed to marshal series: %s", err)
+	}
+}
+
+func TestFlushImmediately(t *testing.T) {
+	now := time.Now().Unix()
+	tm := NewTimer("timer", time.Millisecond)
+	defer arbiter.remove(tm.Meter)
+	m := NewMeter("meter")
+	defer arbiter.remove(m)
+
+	assertFinite(t, tm.Flush(now))
+	assertFinite(t, m.Flush(now))
+	assertFinite(t, NewHistogram("histogram").Flush(now))
+	m.tick()
+	assertFinite(t, m.Flush(now))
+}
+
+func TestTimerNegativeDuration(t *testing.T) {
+	tm := NewTimer("timer", time.Millisecond)
+	defer arbiter.remove(tm.Meter)
+
+	tm.Update(2 * time.Millisecond)
+	tm.Update(-time.Second)
+	tm.Update(4 * time.Millisecond)
+	tm.UpdateSince(time.Now().Add(time.Hour))
+
+	if n := tm.Dropped(); n != 2 {
+		t.Errorf("expected 2 dropped durations, got %d", n)
+	}
+	snap := tm.Snapshot()
+	if snap.Count() != 2 || snap.Min() != int64(2*time.Millisecond) {
+		t.Errorf("expected negative durations to be excluded, got count %d, min %d", snap.Count(), snap.Min())
+	}
+	if n := tm.Count(); n != 2 {
+		t.Errorf("expected 2 marks, got %d", n)
+	}
+}
+
+func TestTimerUsesClock(t *testing.T) {
+	fc := NewFakeClock(time.Unix(1000, 0))
+	SetClock(fc)
+	defer SetClock(nil)
+
+	tm := NewTimer("timer", time.Millisecond)
+	defer arbiter.remove(tm.Meter)
+
+	start := fc.Now()
+	fc.Advance(3 * time.Millisecond)
+	tm.UpdateSince(start)
+	if max := tm.Snapshot().Max(); max != int64(3*time.Millisecond) {
+		t.Errorf("expected duration of 3ms, got %s", time.Duration(max))
+	}
+}
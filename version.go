@@ -0,0 +1,4 @@
+package datadog
+
+// Version is the version of this library, see MetricReporter.TagVersion
+const Version = "0.1.0"